@@ -0,0 +1,127 @@
+package u64
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/templexxx/cpu"
+)
+
+// Allocator allocates memory of tables.
+//
+// Memory from Allocator must be zeroed and won't be scanned by GC (tables have no pointer).
+// Free is called when the table is retired by expanding/shrinking or Close,
+// and no reader is reading it.
+type Allocator interface {
+	Alloc(n int) ([]uint64, error)
+	Free(p []uint64) error
+}
+
+// WithAllocator sets the Allocator of tables, default is the Go heap.
+//
+// With Allocator, readers (Contains, Range) have to announce themselves for safe freeing,
+// it costs two more atomic adds for each reading.
+// Don't Close Set in Range's f, Close waits for readers before freeing tables.
+func WithAllocator(a Allocator) Option {
+	return func(o *options) {
+		o.alloc = a
+	}
+}
+
+// WithMmap allocates tables by mmap (off-heap) on Linux,
+// hugePages enables transparent huge pages (madvise MADV_HUGEPAGE) for tables.
+// It's the Go heap on other platforms.
+//
+// Big tables on the Go heap make GC pacing spikes and zeroing costs,
+// mmap avoids both of them.
+func WithMmap(hugePages bool) Option {
+	return WithAllocator(newMmapAllocator(hugePages))
+}
+
+// makeTbl makes a table which has n slots.
+func (s *Set) makeTbl(n int) ([]uint64, error) {
+	if s.opts.alloc == nil {
+		return make([]uint64, n), nil
+	}
+	return s.opts.alloc.Alloc(n)
+}
+
+// retireTbl frees tbl after all readers which may see it are gone.
+// tbl must be unreachable from cycle already.
+func (s *Set) retireTbl(tbl []uint64) {
+	if s.opts.alloc == nil || tbl == nil {
+		return
+	}
+	s.rec.synchronize()
+	_ = s.opts.alloc.Free(tbl)
+}
+
+// enterRead must be called before reading tables without the write lock,
+// and exitRead after that.
+func (s *Set) enterRead() int {
+	if s.opts.alloc == nil {
+		return -1
+	}
+	return s.rec.enter()
+}
+
+func (s *Set) exitRead(e int) {
+	if e >= 0 {
+		s.rec.exit(e)
+	}
+}
+
+// reclaimer is a two-epoch reader counter (like RCU):
+// readers count themselves in the present epoch,
+// synchronize flips the epoch and waits for readers in the older one.
+type reclaimer struct {
+	_       [cpu.X86FalseSharingRange]byte
+	epoch   uint64
+	_       [cpu.X86FalseSharingRange]byte
+	readers [2]paddedCnt
+
+	mu sync.Mutex // Only one synchronize at the same time.
+}
+
+type paddedCnt struct {
+	n int64
+	_ [cpu.X86FalseSharingRange - 8]byte
+}
+
+func (r *reclaimer) enter() int {
+	for {
+		e := atomic.LoadUint64(&r.epoch)
+		atomic.AddInt64(&r.readers[e&1].n, 1)
+		if atomic.LoadUint64(&r.epoch) == e {
+			return int(e & 1)
+		}
+		// Epoch is flipped, synchronize may miss us, try the new one.
+		atomic.AddInt64(&r.readers[e&1].n, -1)
+	}
+}
+
+func (r *reclaimer) exit(e int) {
+	atomic.AddInt64(&r.readers[e].n, -1)
+}
+
+// synchronize returns after all readers which enter before it are gone.
+func (r *reclaimer) synchronize() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := atomic.AddUint64(&r.epoch, 1) - 1
+	for atomic.LoadInt64(&r.readers[e&1].n) != 0 {
+		pause()
+	}
+}
+
+// heapAllocator allocates tables on the Go heap.
+type heapAllocator struct{}
+
+func (heapAllocator) Alloc(n int) ([]uint64, error) {
+	return make([]uint64, n), nil
+}
+
+func (heapAllocator) Free(_ []uint64) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+package u64
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// mmapAllocator allocates tables by anonymous mmap.
+type mmapAllocator struct {
+	hugePages bool
+}
+
+func newMmapAllocator(hugePages bool) Allocator {
+	return &mmapAllocator{hugePages: hugePages}
+}
+
+func (a *mmapAllocator) Alloc(n int) ([]uint64, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	b, err := syscall.Mmap(-1, 0, n*8, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, err
+	}
+	if a.hugePages {
+		_ = syscall.Madvise(b, syscall.MADV_HUGEPAGE) // It's only an advice, ignore the error (e.g. THP is disabled).
+	}
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&b[0])), n), nil
+}
+
+func (a *mmapAllocator) Free(p []uint64) error {
+	if len(p) == 0 {
+		return nil
+	}
+	return syscall.Munmap(unsafe.Slice((*byte)(unsafe.Pointer(&p[0])), len(p)*8))
+}
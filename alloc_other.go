@@ -0,0 +1,7 @@
+//go:build !linux
+
+package u64
+
+func newMmapAllocator(_ bool) Allocator {
+	return heapAllocator{}
+}
@@ -0,0 +1,126 @@
+package u64
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingAllocator struct {
+	heapAllocator
+	allocs, frees int64
+}
+
+func (a *countingAllocator) Alloc(n int) ([]uint64, error) {
+	atomic.AddInt64(&a.allocs, 1)
+	return a.heapAllocator.Alloc(n)
+}
+
+func (a *countingAllocator) Free(p []uint64) error {
+	atomic.AddInt64(&a.frees, 1)
+	return a.heapAllocator.Free(p)
+}
+
+func TestSet_WithAllocator(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	a := new(countingAllocator)
+	s, _ := New(4096, WithAllocator(a))
+	addWithExpand(t, s, 3<<11)
+	s.Close()
+	s.Close()
+
+	allocs, frees := atomic.LoadInt64(&a.allocs), atomic.LoadInt64(&a.frees)
+	if allocs < 2 || allocs != frees {
+		t.Fatal("allocs & frees mismatched", allocs, frees)
+	}
+}
+
+func TestSet_WithMmap(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	cnt := 3 << 11
+	s, err := New(4096, WithMmap(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers are running when old tables are unmapped.
+	var stop uint32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; atomic.LoadUint32(&stop) == 0; j++ {
+				s.Contains(uint64(j % cnt))
+				if j%1024 == 0 {
+					s.Range(func(_ uint64) bool { return true })
+				}
+				runtime.Gosched() // Let expand run, it may be only one core.
+			}
+		}()
+	}
+
+	addWithExpand(t, s, cnt)
+	for i := 1; i <= cnt; i++ {
+		if !s.Contains(uint64(i)) {
+			t.Fatal("should have key", i)
+		}
+	}
+
+	s.Close()
+	atomic.StoreUint32(&stop, 1)
+	wg.Wait()
+}
+
+// addWithExpand adds [1, cnt] into s and waits for expanding.
+func addWithExpand(t *testing.T, s *Set, cnt int) {
+	t.Helper()
+
+	for i := 1; i <= cnt; i++ {
+		err := s.Add(uint64(i))
+		for err == ErrAddTooFast {
+			time.Sleep(time.Millisecond)
+			err = s.Add(uint64(i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for s.isScaling() {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReclaimer(t *testing.T) {
+
+	var r reclaimer
+	e := r.enter()
+
+	done := make(chan struct{})
+	go func() {
+		r.synchronize()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("synchronize should wait for reader")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	e2 := r.enter() // New reader won't block synchronize.
+	r.exit(e)
+	<-done
+	r.exit(e2)
+	r.synchronize()
+}
@@ -0,0 +1,351 @@
+package u64
+
+import (
+	"errors"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/templexxx/xxh3"
+)
+
+// ApproxSet is an approximate Set which only stores fingerprints of keys,
+// it's in the same neighbourhood layout & two tables cycle as Set.
+//
+// Each entry is 16 or 32 bits:
+// | occupied(1) | offset(6) | remainder(9 or 25) |
+//
+// offset: the distance between the entry and its hashed slot (< neighbour).
+// remainder: the hash bits after the bits of slot index.
+//
+// Entries only have the hash bits (slot index & remainder), so when expanding,
+// the highest bit of remainder becomes the lowest bit of slot index (just like quotient filter),
+// which means the remainder loses one bit in each expanding.
+//
+// False positive rate:
+// Contains is true when there is an entry has the same slot index & remainder,
+// the rate is about load_factor / 2^remainder_bits:
+// 32-bit: 0.9 / 2^25 = 2.7e-8
+// 16-bit: 0.9 / 2^9 = 1.8e-3
+// and it's doubled after each expanding, expanding stops when remainder has minRemainder bits left.
+//
+// Memory:
+// Each key needs about 2.2Bytes (16-bit) or 4.4Bytes (32-bit).
+//
+// Warning:
+// Add of a key which has the same fingerprint as an existed one does nothing,
+// and Remove of a key removes the one which has the same fingerprint,
+// so don't Remove a key which hasn't been added.
+type ApproxSet struct {
+	// set holds status & cycle, tables in cycle are *approxTable.
+	set Set
+	// width is the bits of entry.
+	width uint8
+	// fbits is the bits of fingerprint (slot index & remainder), it never changes.
+	fbits uint8
+}
+
+const (
+	approxSeed = 3
+	// minRemainder is the minimum bits of remainder.
+	minRemainder = 4
+	// offsetBits is the bits of offset in entry, it's enough for neighbour.
+	offsetBits = 6
+)
+
+var ErrInvalidFingerprint = errors.New("fingerprint bits must be 16 or 32")
+
+// approxTable is a table of ApproxSet.
+type approxTable struct {
+	width uint8
+	// bits is the bits of slot index, there are 1<<bits slots (and the extra virtual bucket).
+	bits uint8
+	// rbits is the bits of remainder.
+	rbits uint8
+	// slotCnt is the count of slots (including the extra virtual bucket).
+	slotCnt int
+	// words are the entries, there are two entries in each word in 16-bit.
+	words []uint32
+}
+
+func newApproxTable(width, bits, rbits uint8) *approxTable {
+	slots := calcTableCap(1 << bits)
+	n := slots
+	if width == 16 {
+		n = (slots + 1) / 2
+	}
+	return &approxTable{
+		width:   width,
+		bits:    bits,
+		rbits:   rbits,
+		slotCnt: slots,
+		words:   make([]uint32, n),
+	}
+}
+
+// NewApproxSet creates a new ApproxSet.
+// cap is the set capacity at the beginning, see New for details.
+// fingerprintBits is the bits of each entry, 16 or 32.
+func NewApproxSet(cap int, fingerprintBits int) (*ApproxSet, error) {
+
+	if fingerprintBits != 16 && fingerprintBits != 32 {
+		return nil, ErrInvalidFingerprint
+	}
+
+	cap = int(nextPower2(uint64(cap)))
+
+	if cap < minCap {
+		cap = minCap
+	}
+	if cap > MaxCap {
+		cap = MaxCap
+	}
+
+	width := uint8(fingerprintBits)
+	b := uint8(bits.TrailingZeros(uint(cap)))
+	a := &ApproxSet{width: width, fbits: b + width - 1 - offsetBits}
+	a.set.status = createStatus()
+	t := newApproxTable(width, b, width-1-offsetBits)
+	a.set.reserveMem(t.mem(&a.set, cap))
+	a.set.cycle[0] = unsafe.Pointer(t)
+	return a, nil
+}
+
+// fingerprint returns the fingerprint of key, it's the entry of hop:
+// the highest fbits bits of hash, and the lowest bit is set for telling it from a free slot.
+func (a *ApproxSet) fingerprint(key uint64) uint64 {
+	sh := 64 - uint(a.fbits)
+	return approxHash(key)>>sh<<sh | 1
+}
+
+// tables returns the hopscotch cycle of ApproxSet.
+func (a *ApproxSet) tables() hop[uint64, approxTable, *approxTable] {
+	return hop[uint64, approxTable, *approxTable]{s: &a.set}
+}
+
+// loadEntry loads entry i.
+func (t *approxTable) loadEntry(i int) uint32 {
+	if t.width == 32 {
+		return atomic.LoadUint32(&t.words[i])
+	}
+	w := atomic.LoadUint32(&t.words[i>>1])
+	return (w >> ((i & 1) * 16)) & 0xffff
+}
+
+// storeEntry stores entry i, it must be called under the write lock.
+func (t *approxTable) storeEntry(i int, e uint32) {
+	if t.width == 32 {
+		atomic.StoreUint32(&t.words[i], e)
+		return
+	}
+	p := &t.words[i>>1]
+	sh := uint((i & 1) * 16)
+	w := atomic.LoadUint32(p)
+	atomic.StoreUint32(p, w&^(0xffff<<sh)|e<<sh)
+}
+
+// locate returns the hashed slot & remainder of fingerprint f.
+func (t *approxTable) locate(f uint64) (home int, rem uint32) {
+	home = int(f >> (64 - uint(t.bits)))
+	rem = uint32(f>>(64-uint(t.bits)-uint(t.rbits))) & (1<<t.rbits - 1)
+	return
+}
+
+// entry makes entry by offset & remainder.
+func (t *approxTable) entry(off int, rem uint32) uint32 {
+	return 1<<(t.width-1) | uint32(off)<<(t.width-1-offsetBits) | rem
+}
+
+// decode gets offset & remainder from entry.
+func (t *approxTable) decode(e uint32) (off int, rem uint32) {
+	off = int(e>>(t.width-1-offsetBits)) & (1<<offsetBits - 1)
+	rem = e & (1<<(t.width-1-offsetBits) - 1)
+	return
+}
+
+func (t *approxTable) slots() int {
+	return t.slotCnt
+}
+
+func (t *approxTable) home(_ uint8, f uint64) int {
+	return int(f >> (64 - uint(t.bits)))
+}
+
+// load loads the fingerprint in slot i, it's rebuilt from the slot index & the entry.
+func (t *approxTable) load(i int) uint64 {
+	e := t.loadEntry(i)
+	if e == 0 {
+		return 0
+	}
+	off, rem := t.decode(e)
+	sh := 64 - uint(t.bits) - uint(t.rbits)
+	return (uint64(i-off)<<t.rbits|uint64(rem))<<sh | 1
+}
+
+func (t *approxTable) store(i int, f uint64) {
+	if f == 0 {
+		t.storeEntry(i, 0)
+		return
+	}
+	home, rem := t.locate(f)
+	t.storeEntry(i, t.entry(i-home, rem))
+}
+
+func (t *approxTable) same(a, b uint64) bool {
+	return a == b
+}
+
+// next makes a bigger table, the remainder loses bits as the slot index gets them.
+func (t *approxTable) next(_ *Set, cap int) (*approxTable, error) {
+	b := uint8(bits.TrailingZeros(uint(cap)))
+	rbits := int(t.rbits) - int(b-t.bits)
+	if rbits < minRemainder {
+		return nil, ErrIsFull
+	}
+	return newApproxTable(t.width, b, uint8(rbits)), nil
+}
+
+func (t *approxTable) mem(_ *Set, cap int) int64 {
+	n := calcTableCap(cap)
+	if t.width == 16 {
+		n = (n + 1) / 2
+	}
+	return int64(n) * 4
+}
+
+func (t *approxTable) retire(_ *Set) {}
+
+func (t *approxTable) inserting(_ *Set, _ uint8, _ uint64) {}
+
+func getApproxTbl(a *ApproxSet, idx int) *approxTable {
+	return (*approxTable)(atomic.LoadPointer(&a.set.cycle[idx]))
+}
+
+func approxHash(key uint64) uint64 {
+	return xxh3.HashU64(key, approxSeed)
+}
+
+// Contains returns the key in set or not,
+// it may be false positive (see ApproxSet for details).
+func (a *ApproxSet) Contains(key uint64) bool {
+
+	sa := atomic.LoadUint64(&a.set.status)
+	if !bitOne(sa, 63) {
+		return false
+	}
+
+	f := a.fingerprint(key)
+	h := a.tables()
+	widx := getWritableIdxByStatus(sa)
+	for _, idx := range [2]uint8{widx, widx ^ 1} {
+		if has, _ := h.find(h.table(idx), idx, f); has {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds key into ApproxSet.
+// Return nil if succeed.
+func (a *ApproxSet) Add(key uint64) error {
+
+	s := &a.set
+	if !s.IsRunning() {
+		return ErrIsClosed
+	}
+
+	f := a.fingerprint(key)
+
+restart:
+	if !s.lock() {
+		pause()
+		goto restart
+	}
+
+	if !s.IsRunning() {
+		s.unlock()
+		return ErrIsClosed
+	}
+
+	if s.isSealed() {
+		s.unlock()
+		return ErrIsSealed
+	}
+
+	h := a.tables()
+	err := h.put(f, true)
+	if err == ErrIsFull {
+		// Last writable table is full, try to expand to new table.
+		err = h.grow(f)
+	}
+	switch err {
+	case nil:
+		s.addCnt()
+		s.unlock()
+		return nil
+	case ErrExisted:
+		s.unlock()
+		return nil
+	default:
+		s.unlock()
+		return err
+	}
+}
+
+// Remove removes key's fingerprint in ApproxSet.
+func (a *ApproxSet) Remove(key uint64) {
+
+	s := &a.set
+	if !s.IsRunning() {
+		return
+	}
+
+	f := a.fingerprint(key)
+
+restart:
+	if !s.lock() {
+		pause()
+		goto restart
+	}
+
+	if !s.IsRunning() {
+		s.unlock()
+		return
+	}
+
+	if a.tables().remove(f) {
+		s.delCnt()
+	}
+	s.unlock()
+}
+
+// GetUsage returns ApproxSet capacity & usage.
+func (a *ApproxSet) GetUsage() (total, usage int) {
+	if !a.set.IsRunning() {
+		return 0, 0
+	}
+	if t := getApproxTbl(a, int(a.set.getWritableIdx())); t != nil {
+		total = 1 << t.bits
+	}
+	return total, int(a.set.getCnt())
+}
+
+// FalsePositiveRate returns the estimated false positive rate of Contains in present.
+func (a *ApproxSet) FalsePositiveRate() float64 {
+	t := getApproxTbl(a, int(a.set.getWritableIdx()))
+	if t == nil {
+		return 0
+	}
+	load := float64(a.set.getCnt()) / float64(uint64(1)<<t.bits)
+	return load / float64(uint64(1)<<t.rbits)
+}
+
+// IsRunning returns ApproxSet is running or not.
+func (a *ApproxSet) IsRunning() bool {
+	return a.set.IsRunning()
+}
+
+// Close closes ApproxSet and release the resource.
+func (a *ApproxSet) Close() {
+	a.set.Close()
+}
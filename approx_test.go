@@ -0,0 +1,101 @@
+package u64
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewApproxSet(t *testing.T) {
+	_, err := NewApproxSet(0, 8)
+	if err != ErrInvalidFingerprint {
+		t.Fatal("should be invalid")
+	}
+}
+
+func TestApproxSet_Contains(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	for _, width := range []int{16, 32} {
+		testApproxSetContains(t, width)
+	}
+}
+
+func testApproxSetContains(t *testing.T, width int) {
+
+	cnt := 3 << 11
+	a, err := NewApproxSet(4096, width) // Not enough capacity, must trigger expand.
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	for i := 0; i < cnt; i++ {
+		err = a.Add(uint64(i))
+		for err == ErrAddTooFast { // Waiting for expanding.
+			time.Sleep(time.Millisecond)
+			err = a.Add(uint64(i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.Contains(uint64(i)) {
+			t.Fatal("should have key")
+		}
+	}
+	for a.set.isScaling() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < cnt; i++ {
+		if !a.Contains(uint64(i)) {
+			t.Fatal("should have key")
+		}
+	}
+
+	fp := 0
+	for i := cnt; i < cnt*21; i++ {
+		if a.Contains(uint64(i)) {
+			fp++
+		}
+	}
+	rate := float64(fp) / float64(cnt*20)
+	exp := a.FalsePositiveRate()
+	if rate > exp*2+1e-6 {
+		t.Fatal("false positive rate too high", width, rate, exp)
+	}
+	t.Logf("width: %d, false positive rate: %.8f, estimated: %.8f", width, rate, exp)
+
+	total, usage := a.GetUsage()
+	if total != 8192 || usage > cnt || usage < cnt-cnt/100 {
+		t.Fatal("usage mismatched", total, usage)
+	}
+
+	for i := 0; i < cnt; i++ {
+		a.Remove(uint64(i))
+	}
+	_, usage = a.GetUsage()
+	if usage != 0 {
+		t.Fatal("usage mismatched", usage)
+	}
+}
+
+func TestApproxSet_Close(t *testing.T) {
+
+	a, _ := NewApproxSet(0, 16)
+	_ = a.Add(1)
+	a.Close()
+
+	if err := a.Add(2); err != ErrIsClosed {
+		t.Fatal("Add should return ErrIsClosed", err)
+	}
+	if a.Contains(1) {
+		t.Fatal("Contains should be false")
+	}
+	a.Remove(1)
+	if total, usage := a.GetUsage(); total != 0 || usage != 0 {
+		t.Fatal("GetUsage should be zeros", total, usage)
+	}
+}
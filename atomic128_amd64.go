@@ -0,0 +1,29 @@
+package u64
+
+import "github.com/templexxx/cpu"
+
+var (
+	isAtomic128 = cpu.X86.HasCMPXCHG16B
+	// Aligned 16Bytes loading is atomic on CPUs which support AVX,
+	// it's much faster than CMPXCHG16B (which is a write).
+	isLoad128AVX = cpu.X86.HasAVX
+)
+
+// load128 loads 16Bytes at p atomically, p must be aligned to 16Bytes.
+func load128(p *uint64) (lo, hi uint64) {
+	if isLoad128AVX {
+		return load128AVX(p)
+	}
+	return load128CAS(p)
+}
+
+//go:noescape
+func load128AVX(p *uint64) (lo, hi uint64)
+
+//go:noescape
+func load128CAS(p *uint64) (lo, hi uint64)
+
+// store128 stores 16Bytes at p atomically, p must be aligned to 16Bytes.
+//
+//go:noescape
+func store128(p *uint64, lo, hi uint64)
@@ -0,0 +1,41 @@
+#include "textflag.h"
+
+// func load128AVX(p *uint64) (lo, hi uint64)
+// p must be aligned to 16Bytes,
+// aligned 16Bytes loading is atomic on CPUs which support AVX.
+TEXT ·load128AVX(SB), NOSPLIT, $0
+    MOVQ    p+0(FP), AX
+    VMOVDQA (AX), X0
+    MOVQ    X0, lo+8(FP)
+    VPEXTRQ $1, X0, hi+16(FP)
+    RET
+
+// func load128CAS(p *uint64) (lo, hi uint64)
+// p must be aligned to 16Bytes.
+// Compare with zero & swap with zero, so the value won't be changed.
+TEXT ·load128CAS(SB), NOSPLIT, $0
+    MOVQ p+0(FP), DI
+    XORQ AX, AX
+    XORQ DX, DX
+    XORQ BX, BX
+    XORQ CX, CX
+    LOCK
+    CMPXCHG16B (DI)
+    MOVQ AX, lo+8(FP)
+    MOVQ DX, hi+16(FP)
+    RET
+
+// func store128(p *uint64, lo, hi uint64)
+// p must be aligned to 16Bytes.
+TEXT ·store128(SB), NOSPLIT, $0
+    MOVQ p+0(FP), DI
+    MOVQ lo+8(FP), BX
+    MOVQ hi+16(FP), CX
+    MOVQ 0(DI), AX
+    MOVQ 8(DI), DX
+
+loop:
+    LOCK
+    CMPXCHG16B (DI)
+    JNE loop
+    RET
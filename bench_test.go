@@ -12,8 +12,8 @@ type bench struct {
 
 const initCap = 1 << 10 // Avoiding no slot to add.
 
-func benchSet(b *testing.B, bench bench) {
-	s, _ := New(initCap)
+func benchSet(b *testing.B, bench bench, opts ...Option) {
+	s, _ := New(initCap, opts...)
 	b.Run("", func(b *testing.B) {
 		if bench.setup != nil {
 			bench.setup(b, s)
@@ -73,6 +73,28 @@ func BenchmarkContainsMostlyMisses(b *testing.B) {
 	})
 }
 
+func BenchmarkContainsMostlyMissesWithBloom(b *testing.B) {
+	const hits, misses = 1, 1023
+
+	benchSet(b, bench{
+		setup: func(_ *testing.B, s *Set) {
+			for i := uint64(0); i < hits; i++ {
+				_ = s.Add(i)
+			}
+			// Prime the set to get it into a steady state.
+			for i := uint64(0); i < hits*2; i++ {
+				s.Contains(i % hits)
+			}
+		},
+
+		perG: func(b *testing.B, pb *testing.PB, i uint64, s *Set) {
+			for ; pb.Next(); i++ {
+				s.Contains(i % (hits + misses))
+			}
+		},
+	}, WithBloom(10))
+}
+
 func BenchmarkAddContainsBalanced(b *testing.B) {
 	const hits, misses = 128, 128
 
@@ -0,0 +1,128 @@
+package u64
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/templexxx/xxh3"
+)
+
+const (
+	// bloomBlockBits is the bits of a bloom block, it's a cache line.
+	bloomBlockBits = 512
+	bloomBlockSize = bloomBlockBits / 64
+	// bloomMaxK is the maximum count of hash functions,
+	// each one needs 9 bits, and there are 64 bits in total.
+	bloomMaxK = 7
+	// bloomSeed is the hash seed of bloom filter, different with tables'.
+	bloomSeed = 2
+)
+
+// bloom is a blocked Bloom filter, each key only touches one block (cache line).
+//
+// It's written under the write lock of Set, and read wait-free.
+type bloom struct {
+	blocks []uint64
+	mask   uint64 // mask of block index.
+	k      int
+}
+
+// newBloom creates a bloom filter for cap keys.
+func newBloom(cap, bitsPerKey int) *bloom {
+
+	n := bloomBlocks(cap, bitsPerKey)
+
+	k := int(math.Round(float64(bitsPerKey) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	if k > bloomMaxK {
+		k = bloomMaxK
+	}
+
+	return &bloom{
+		blocks: make([]uint64, n*bloomBlockSize),
+		mask:   uint64(n - 1),
+		k:      k,
+	}
+}
+
+// bloomBlocks returns the count of blocks of bloom filter for cap keys.
+func bloomBlocks(cap, bitsPerKey int) int {
+	n := (cap*bitsPerKey + bloomBlockBits - 1) / bloomBlockBits
+	return int(nextPower2(uint64(n)))
+}
+
+// locate returns the block offset & the hash for bits in block.
+func (b *bloom) locate(key uint64) (off int, h uint64) {
+	h = xxh3.HashU64(key, bloomSeed)
+	off = int((h>>32)&b.mask) * bloomBlockSize
+	return off, h * 0x9e3779b97f4a7c15 // Mix again for bits in block.
+}
+
+// add adds key into bloom, it must be called under the write lock.
+func (b *bloom) add(key uint64) {
+	off, h := b.locate(key)
+	blk := b.blocks[off : off+bloomBlockSize]
+	for i := 0; i < b.k; i++ {
+		bit := h & (bloomBlockBits - 1)
+		h >>= 9
+		w := &blk[bit>>6]
+		v := atomic.LoadUint64(w)
+		if v&(1<<(bit&63)) == 0 {
+			atomic.StoreUint64(w, v|1<<(bit&63)) // Only one writer.
+		}
+	}
+}
+
+// has returns key may be in bloom or not.
+func (b *bloom) has(key uint64) bool {
+	off, h := b.locate(key)
+	blk := b.blocks[off : off+bloomBlockSize]
+	for i := 0; i < b.k; i++ {
+		bit := h & (bloomBlockBits - 1)
+		h >>= 9
+		if atomic.LoadUint64(&blk[bit>>6])&(1<<(bit&63)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// fpRate estimates the false positive rate by the fraction of set bits.
+// It's O(n) with the size of bloom.
+func (b *bloom) fpRate() float64 {
+	cnt := 0
+	for i := range b.blocks {
+		cnt += bits.OnesCount64(atomic.LoadUint64(&b.blocks[i]))
+	}
+	return math.Pow(float64(cnt)/float64(len(b.blocks)*64), float64(b.k))
+}
+
+// newTblBloom creates bloom filter for a table which origin capacity is cap,
+// returns nil if bloom filter is disabled.
+func (s *Set) newTblBloom(cap int) unsafe.Pointer {
+	if s.opts.bloomBits <= 0 {
+		return nil
+	}
+	return unsafe.Pointer(newBloom(cap, s.opts.bloomBits))
+}
+
+func getBloom(s *Set, idx int) *bloom {
+	return (*bloom)(atomic.LoadPointer(&s.blooms[idx]))
+}
+
+// bloomAdd adds key into the bloom filter of table idx if has.
+func (s *Set) bloomAdd(idx uint8, key uint64) {
+	if b := getBloom(s, int(idx)); b != nil {
+		b.add(key)
+	}
+}
+
+// bloomMiss returns true if key must not be in table idx.
+func (s *Set) bloomMiss(idx uint8, key uint64) bool {
+	b := getBloom(s, int(idx))
+	return b != nil && !b.has(key)
+}
@@ -0,0 +1,77 @@
+package u64
+
+import (
+	"testing"
+)
+
+func TestBloom(t *testing.T) {
+
+	n := 1 << 16
+	b := newBloom(n, 10)
+	for i := 0; i < n; i++ {
+		b.add(uint64(i))
+	}
+	for i := 0; i < n; i++ {
+		if !b.has(uint64(i)) {
+			t.Fatal("should have key")
+		}
+	}
+
+	fp := 0
+	for i := n; i < n*2; i++ {
+		if b.has(uint64(i)) {
+			fp++
+		}
+	}
+	rate := float64(fp) / float64(n)
+	if rate > 0.03 {
+		t.Fatal("false positive rate too high", rate)
+	}
+	est := b.fpRate()
+	if est <= 0 || est > 0.03 {
+		t.Fatal("estimated false positive rate mismatched", est, rate)
+	}
+}
+
+func TestSet_WithBloom(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	cnt := 3 << 11
+	s, _ := New(4096, WithBloom(10)) // Not enough capacity, must trigger expand.
+	defer s.Close()
+
+	if s.Stats().BloomFPRate != 0 {
+		t.Fatal("empty bloom filter should have no false positive")
+	}
+
+	for i := 0; i < cnt; i++ {
+		err := s.Add(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !s.Contains(uint64(i)) {
+			t.Fatal("should have key")
+		}
+	}
+	for i := 0; i < cnt; i++ {
+		if !s.Contains(uint64(i)) {
+			t.Fatal("should have key")
+		}
+	}
+	for i := cnt; i < cnt*2; i++ {
+		if s.Contains(uint64(i)) {
+			t.Fatal("should not have key")
+		}
+	}
+
+	st := s.Stats()
+	if st.BloomFPRate <= 0 || st.BloomFPRate > 0.05 {
+		t.Fatal("false positive rate mismatched", st.BloomFPRate)
+	}
+	if st.Usage != cnt-1 {
+		t.Fatal("usage mismatched", st.Usage)
+	}
+}
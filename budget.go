@@ -0,0 +1,118 @@
+package u64
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrOverBudget is returned when creating, expanding or shrinking a Set
+// needs more memory than its Budget has.
+var ErrOverBudget = errors.New("over memory budget")
+
+// Budget is a memory limit shared by Sets, Sets are attached by WithBudget.
+//
+// It accounts the bytes of tables (and bloom filters) in cycle,
+// including the new table during expanding/shrinking (both tables are alive then).
+// Other bytes (e.g. the Set struct itself) are not counted.
+type Budget struct {
+	limit int64
+	used  int64
+
+	mu   sync.Mutex
+	sets map[*Set]struct{}
+}
+
+// NewBudget creates a Budget which has limit bytes.
+func NewBudget(limit int64) *Budget {
+	return &Budget{
+		limit: limit,
+		sets:  make(map[*Set]struct{}),
+	}
+}
+
+// WithBudget attaches Set to b.
+// New returns ErrOverBudget if there is no enough budget for the first table,
+// and expanding fails with ErrOverBudget (returned by Add) when it's over budget.
+func WithBudget(b *Budget) Option {
+	return func(o *options) {
+		o.budget = b
+	}
+}
+
+// Limit returns the limit bytes.
+func (b *Budget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the bytes used by all attached Sets.
+func (b *Budget) Used() int64 {
+	return atomic.LoadInt64(&b.used)
+}
+
+// Report returns the bytes used by each attached Set which isn't closed.
+func (b *Budget) Report() map[*Set]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := make(map[*Set]int64, len(b.sets))
+	for s := range b.sets {
+		r[s] = atomic.LoadInt64(&s.mem)
+	}
+	return r
+}
+
+// reserve reserves n bytes, returns false if it's over limit.
+func (b *Budget) reserve(n int64) bool {
+	for {
+		used := atomic.LoadInt64(&b.used)
+		if used+n > b.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.used, used, used+n) {
+			return true
+		}
+	}
+}
+
+func (b *Budget) free(n int64) {
+	atomic.AddInt64(&b.used, -n)
+}
+
+func (b *Budget) register(s *Set) {
+	b.mu.Lock()
+	b.sets[s] = struct{}{}
+	b.mu.Unlock()
+}
+
+func (b *Budget) unregister(s *Set) {
+	b.mu.Lock()
+	delete(b.sets, s)
+	b.mu.Unlock()
+}
+
+// memOfCap returns the bytes of a table (and its bloom filter) which has cap capacity.
+func (s *Set) memOfCap(cap int) int64 {
+	n := calcTableCap(cap)
+	if s.opts.bloomBits > 0 {
+		n += bloomBlocks(cap, s.opts.bloomBits) * bloomBlockSize
+	}
+	return int64(n) * 8
+}
+
+// reserveMem reserves n bytes for Set, returns false if it's over budget.
+func (s *Set) reserveMem(n int64) bool {
+	if b := s.opts.budget; b != nil && !b.reserve(n) {
+		return false
+	}
+	atomic.AddInt64(&s.mem, n)
+	return true
+}
+
+// freeMem frees n bytes of Set.
+func (s *Set) freeMem(n int64) {
+	atomic.AddInt64(&s.mem, -n)
+	if b := s.opts.budget; b != nil {
+		b.free(n)
+	}
+}
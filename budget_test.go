@@ -0,0 +1,77 @@
+package u64
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	small := (&Set{}).memOfCap(1024)
+	b := NewBudget(small * 4) // Enough for s0 (1024) & s1 (1024 + 2048) during expanding.
+
+	s0, err := New(1024, WithBudget(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s0.Close()
+	s1, err := New(1024, WithBudget(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Close()
+
+	if _, err = New(4096, WithBudget(b)); err != ErrOverBudget {
+		t.Fatal("should be over budget", err)
+	}
+	if b.Used() != small*2 {
+		t.Fatal("used mismatched", b.Used())
+	}
+
+	// s1 expands to 2048, only the new table is left after expanding.
+	for i := 1; i <= 1200; i++ {
+		err := s1.Add(uint64(i))
+		for err == ErrAddTooFast {
+			time.Sleep(time.Millisecond)
+			err = s1.Add(uint64(i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for s1.isScaling() {
+		time.Sleep(time.Millisecond)
+	}
+	big := s1.memOfCap(2048)
+	if b.Used() != small+big {
+		t.Fatal("used mismatched", b.Used(), small+big)
+	}
+	r := b.Report()
+	if len(r) != 2 || r[s0] != small || r[s1] != big || s1.Stats().Bytes != big {
+		t.Fatal("report mismatched", r)
+	}
+
+	// s0 can't expand to 2048: small + small + 2*small > 4*small.
+	for i := 1; ; i++ {
+		err = s0.Add(uint64(i))
+		if err == ErrOverBudget {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Used() != small+big {
+		t.Fatal("used mismatched", b.Used())
+	}
+
+	s1.Close()
+	s1.Close()
+	if b.Used() != small || len(b.Report()) != 1 {
+		t.Fatal("s1 should be released", b.Used())
+	}
+}
@@ -0,0 +1,160 @@
+package u64
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// buildMinRange is the minimum slots of each worker's range in BuildFrom,
+// keys hashed near the end of range may have no place (overflow),
+// the range should be much bigger than neighbour.
+const buildMinRange = neighbour * 16
+
+// BuildFrom creates a Set with keys in bulk, duplicated keys are allowed.
+//
+// It sizes the table once, partitions keys by slot range across goroutines,
+// inserts keys without the global lock (each goroutine only writes its own range),
+// and adds the overflowed keys (no free slot in range) in a final pass.
+// The result is a normal Set, keys could be Added or Removed after that.
+func BuildFrom(keys []uint64, opts ...Option) (*Set, error) {
+
+	o := makeOptions(opts)
+
+	cap := len(keys) + len(keys)/3 // Keeping load factor low, less overflow.
+	s, err := New(cap, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	tbl := getTbl(s, 0)
+	slotCnt := len(tbl)
+	mask := calcMask(uint32(slotCnt))
+
+	workers := o.buildWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if limit := (int(mask) + 1) / buildMinRange; workers > limit {
+		workers = limit
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	rangeSize := (int(mask) + 1 + workers - 1) / workers
+
+	// 1. Partition keys by hashed slot.
+	parts := make([][][]uint64, workers) // parts[chunk][worker]
+	chunkSize := (len(keys) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for c := 0; c < workers; c++ {
+		lo, hi := c*chunkSize, (c+1)*chunkSize
+		if lo > len(keys) {
+			lo = len(keys)
+		}
+		if hi > len(keys) {
+			hi = len(keys)
+		}
+		wg.Add(1)
+		go func(c int, chunk []uint64) {
+			defer wg.Done()
+			p := make([][]uint64, workers)
+			for _, key := range chunk {
+				w := int(calcHash(0, key)&mask) / rangeSize
+				p[w] = append(p[w], key)
+			}
+			parts[c] = p
+		}(c, keys[lo:hi])
+	}
+	wg.Wait()
+
+	// 2. Insert keys in each range.
+	overflows := make([][]uint64, workers)
+	cnts := make([]int, workers)
+	digests := make([]digest, workers)
+	hasZero := int32(0)
+	for w := 0; w < workers; w++ {
+		lo, hi := w*rangeSize, (w+1)*rangeSize
+		if w == workers-1 {
+			hi = slotCnt // The last one has the extra slots.
+		}
+		wg.Add(1)
+		go func(w, lo, hi int) {
+			defer wg.Done()
+			for c := range parts {
+				for _, key := range parts[c][w] {
+					if key == 0 {
+						atomic.StoreInt32(&hasZero, 1)
+						continue
+					}
+					ok, added := buildInsert(tbl, hi, int(calcHash(0, key)&mask), key)
+					if !ok {
+						overflows[w] = append(overflows[w], key)
+						continue
+					}
+					if added {
+						cnts[w]++
+						digests[w].add(key)
+					}
+				}
+			}
+		}(w, lo, hi)
+	}
+	wg.Wait()
+
+	cnt := 0
+	for w, n := range cnts {
+		cnt += n
+		for i, v := range digests[w] {
+			s.digest[i] += v
+		}
+	}
+	atomic.AddUint64(&s.status, uint64(cnt)) // cnt is the lowest bits.
+	if hasZero == 1 {
+		s.addZero()
+		s.digest.add(0)
+	}
+	if b := getBloom(s, 0); b != nil {
+		for _, k := range tbl {
+			if k != 0 {
+				b.add(k)
+			}
+		}
+	}
+
+	// 3. Add overflowed keys by the normal way.
+	for _, keys := range overflows {
+		for _, key := range keys {
+			err = s.Add(key)
+			for err == ErrAddTooFast { // Waiting for expanding.
+				runtime.Gosched()
+				err = s.Add(key)
+			}
+			if err != nil {
+				s.Close() // Releasing budget, tables & the expanding goroutine.
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// buildInsert inserts key into tbl within [slot, end) without atomic,
+// returns ok is false if there is no free slot.
+func buildInsert(tbl []uint64, end, slot int, key uint64) (ok, added bool) {
+	n := neighbour
+	if slot+neighbour >= end {
+		n = end - slot
+	}
+	for i := 0; i < n; i++ {
+		k := tbl[slot+i]
+		if k == key {
+			return true, false
+		}
+		if k == 0 {
+			tbl[slot+i] = key
+			return true, true
+		}
+	}
+	return false, false
+}
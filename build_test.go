@@ -0,0 +1,100 @@
+package u64
+
+import (
+	"testing"
+)
+
+func TestBuildFrom(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	for _, n := range []int{0, 1, 1024, 1 << 18} {
+		keys := generateKeys(n, randomKey)
+		keys = append(keys, keys[:n/2]...) // Duplicated keys.
+		keys = append(keys, 0)
+
+		s, err := BuildFrom(keys, WithBloom(10))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := make(map[uint64]struct{}, n)
+		for _, key := range keys {
+			exp[key] = struct{}{}
+			if !s.Contains(key) {
+				t.Fatal("should have key")
+			}
+		}
+		_, usage := s.GetUsage()
+		if usage != len(exp)-1 { // 0 isn't counted.
+			t.Fatal("usage mismatched", usage, len(exp)-1)
+		}
+
+		cnt := 0
+		s.Range(func(key uint64) bool {
+			if _, ok := exp[key]; !ok {
+				t.Fatal("should not have key")
+			}
+			cnt++
+			return true
+		})
+		if cnt != len(exp) {
+			t.Fatal("range mismatched", cnt, len(exp))
+		}
+
+		// It's a normal Set.
+		added, err := s.TryAdd(uint64(n*4 + 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added {
+			t.Fatal("should be added")
+		}
+	}
+}
+
+func TestBuildFrom_Workers(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	keys := generateKeys(1<<16, sortKey)
+	for _, w := range []int{1, 3, 64} {
+		s, err := BuildFrom(keys, WithBuildWorkers(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if !s.Contains(key) {
+				t.Fatal("should have key")
+			}
+		}
+		_, usage := s.GetUsage()
+		if usage != len(keys) {
+			t.Fatal("usage mismatched", usage)
+		}
+	}
+}
+
+func BenchmarkBuildFrom(b *testing.B) {
+
+	keys := generateKeys(1<<20, randomKey)
+
+	b.Run("add", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s, _ := New(len(keys) + len(keys)/3)
+			for _, key := range keys {
+				_ = s.Add(key)
+			}
+		}
+	})
+
+	b.Run("build", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = BuildFrom(keys)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package u64
+
+import (
+	"sync/atomic"
+)
+
+// Cache is a bounded Set for caching "recently seen" keys.
+//
+// Cache never expands, when it's full (reaching the hard capacity,
+// or there is no free slot in the key's neighbourhood),
+// Add evicts a victim chosen by CLOCK (second-chance) instead of returning ErrIsFull:
+// each slot has a reference bit set by Contains, victim must have no reference bit,
+// the hand clears reference bits when passing.
+//
+// Key 0 is flagged by status as Set, it won't be evicted and not counted in capacity.
+type Cache struct {
+	set *Set
+
+	capacity int
+	// refs is the reference bits of slots, 32 slots in each element.
+	// Reference bits stay with slots when hopscotch swapping moves a key,
+	// which only costs the moved key its second chance.
+	refs []uint32
+	// hand is the CLOCK hand for evicting when reaching capacity,
+	// it's protected by the write lock of set.
+	hand    int
+	evicted uint64
+}
+
+// CacheStats is the statistics of Cache.
+type CacheStats struct {
+	// Capacity is the hard capacity.
+	Capacity int
+	// Usage is the count of keys.
+	Usage int
+	// Evicted is the count of evicted keys.
+	Evicted uint64
+}
+
+// NewCache creates a new Cache which has capacity keys at most.
+// If capacity is out of [minCap, MaxCap], using the closest one.
+func NewCache(capacity int) (*Cache, error) {
+
+	if capacity < minCap {
+		capacity = minCap
+	}
+	if capacity > MaxCap {
+		capacity = MaxCap
+	}
+
+	// Leave a bit extra slots, or neighbourhood will be full too often.
+	s, err := New(capacity + capacity/8)
+	if err != nil {
+		return nil, err
+	}
+	tbl := getTbl(s, 0)
+	return &Cache{
+		set:      s,
+		capacity: capacity,
+		refs:     make([]uint32, (len(tbl)+31)/32),
+	}, nil
+}
+
+// Add adds key into Cache, it may evict another key.
+// Return nil if succeed.
+func (c *Cache) Add(key uint64) error {
+	_, err := c.TryAdd(key)
+	return err
+}
+
+// TryAdd adds key into Cache like Add,
+// and reports whether key was absent before (added is true).
+// Adding an existed key is a reference to it.
+func (c *Cache) TryAdd(key uint64) (added bool, err error) {
+
+	s := c.set
+	if !s.IsRunning() {
+		return false, ErrIsClosed
+	}
+
+	err = s.tryAdd(key, false)
+	if key == 0 && err != ErrIsClosed {
+		s.unlock()
+		return err == nil, nil
+	}
+
+	tbl := getTbl(s, 0)
+	switch err {
+	case nil:
+		_, pos := getPosition(tbl, getSlot(0, tbl, key), key)
+		c.clrRef(pos) // Clean the reference bit of the last key in this slot.
+		s.addCnt()
+		if int(s.getCnt()) > c.capacity {
+			c.evictByHand(tbl, pos)
+		}
+		s.unlock()
+		return true, nil
+
+	case ErrExisted:
+		_, pos := getPosition(tbl, getSlot(0, tbl, key), key)
+		c.setRef(pos)
+		s.unlock()
+		return false, nil
+
+	case ErrIsFull:
+		if tbl == nil {
+			s.unlock()
+			return false, ErrIsClosed
+		}
+		c.evictInNeighbour(tbl, key)
+		s.unlock()
+		return true, nil
+
+	default:
+		s.unlock()
+		return false, err
+	}
+}
+
+// evictByHand evicts a victim from the hand, the key in protected slot can't be evicted.
+// Cache must be locked.
+func (c *Cache) evictByHand(tbl []uint64, protected int) {
+
+	n := len(tbl)
+	for i := 0; i < n*2; i++ { // In the second round, all reference bits are clean.
+		pos := c.hand
+		c.hand++
+		if c.hand >= n {
+			c.hand = 0
+		}
+		if pos == protected || atomic.LoadUint64(&tbl[pos]) == 0 {
+			continue
+		}
+		if c.clrRef(pos) {
+			continue // Second chance.
+		}
+		atomic.StoreUint64(&tbl[pos], 0)
+		c.set.delCnt()
+		atomic.AddUint64(&c.evicted, 1)
+		return
+	}
+}
+
+// evictInNeighbour replaces a victim in key's neighbourhood by key,
+// the neighbourhood must be full.
+// Cache must be locked.
+func (c *Cache) evictInNeighbour(tbl []uint64, key uint64) {
+
+	slot := getSlot(0, tbl, key)
+	n := neighbour
+	if slot+neighbour >= len(tbl) {
+		n = len(tbl) - slot
+	}
+
+	// Not always start at the first one, or it will be evicted again and again.
+	start := int(atomic.LoadUint64(&c.evicted) % uint64(n))
+	pos := slot + start
+	for i := 0; i < n*2; i++ {
+		pos = slot + (start+i)%n
+		if !c.clrRef(pos) {
+			break
+		}
+	}
+	atomic.StoreUint64(&tbl[pos], key)
+	atomic.AddUint64(&c.evicted, 1)
+}
+
+// setRef sets the reference bit of slot pos.
+func (c *Cache) setRef(pos int) {
+	p := &c.refs[pos>>5]
+	bit := uint32(1) << (pos & 31)
+	for {
+		old := atomic.LoadUint32(p)
+		if old&bit != 0 || atomic.CompareAndSwapUint32(p, old, old|bit) {
+			return
+		}
+	}
+}
+
+// clrRef clears the reference bit of slot pos, returns it was set or not.
+func (c *Cache) clrRef(pos int) bool {
+	p := &c.refs[pos>>5]
+	bit := uint32(1) << (pos & 31)
+	for {
+		old := atomic.LoadUint32(p)
+		if old&bit == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(p, old, old&^bit) {
+			return true
+		}
+	}
+}
+
+// Contains returns the key in Cache or not.
+// It's a reference to the key, so it sets the key's reference bit.
+//
+// Contains is lock-free: setting the reference bit may retry when other readers
+// are setting bits nearby.
+func (c *Cache) Contains(key uint64) bool {
+
+	sa := atomic.LoadUint64(&c.set.status)
+	if !bitOne(sa, 63) {
+		return false
+	}
+
+	if key == 0 {
+		return bitOne(sa, 58)
+	}
+
+	tbl := getTbl(c.set, 0)
+	has, pos := getPosition(tbl, getSlot(0, tbl, key), key)
+	if has {
+		c.setRef(pos)
+	}
+	return has
+}
+
+// Remove removes key in Cache.
+func (c *Cache) Remove(key uint64) {
+	c.set.Remove(key)
+}
+
+// Range calls f sequentially for each key present in the Cache,
+// it won't set reference bits.
+// See Set.Range for more details.
+func (c *Cache) Range(f func(key uint64) bool) {
+	c.set.Range(f)
+}
+
+// GetUsage returns Cache hard capacity & usage.
+func (c *Cache) GetUsage() (total, usage int) {
+	if !c.set.IsRunning() {
+		return 0, 0
+	}
+	return c.capacity, int(c.set.getCnt())
+}
+
+// Stats returns the statistics of Cache.
+func (c *Cache) Stats() CacheStats {
+	st := CacheStats{Evicted: atomic.LoadUint64(&c.evicted)}
+	st.Capacity, st.Usage = c.GetUsage()
+	return st
+}
+
+// IsRunning returns Cache is running or not.
+func (c *Cache) IsRunning() bool {
+	return c.set.IsRunning()
+}
+
+// Close closes Cache and release the resource.
+func (c *Cache) Close() {
+	c.set.Close()
+}
@@ -0,0 +1,138 @@
+package u64
+
+import (
+	"testing"
+)
+
+func TestCache_Evict(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	capacity := 1024
+	c, err := NewCache(capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	n := capacity * 4
+	for i := 1; i <= n; i++ {
+		added, err := c.TryAdd(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added {
+			t.Fatal("should be added")
+		}
+		if !c.Contains(uint64(i)) {
+			t.Fatal("should have key")
+		}
+	}
+
+	st := c.Stats()
+	if st.Usage != capacity {
+		t.Fatal("usage mismatched", st.Usage)
+	}
+	if st.Evicted != uint64(n-capacity) {
+		t.Fatal("evicted mismatched", st.Evicted)
+	}
+
+	cnt := 0
+	c.Range(func(key uint64) bool {
+		cnt++
+		return true
+	})
+	if cnt != capacity {
+		t.Fatal("range mismatched", cnt)
+	}
+}
+
+func TestCache_SecondChance(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	capacity := 64
+	c, err := NewCache(capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 1; i <= capacity; i++ {
+		err = c.Add(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= capacity/2; i++ {
+		if !c.Contains(uint64(i)) {
+			t.Fatal("should have key")
+		}
+	}
+
+	// Only the keys without reference could be evicted.
+	for i := capacity + 1; i <= capacity+capacity/2; i++ {
+		err = c.Add(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= capacity/2; i++ {
+		if !c.Contains(uint64(i)) {
+			t.Fatal("referenced key should not be evicted", i)
+		}
+	}
+}
+
+func TestCache_EvictInNeighbour(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	c, err := NewCache(neighbour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Make neighbourhood full by filling table directly.
+	tbl := getTbl(c.set, 0)
+	for i := range tbl {
+		tbl[i] = uint64(i + 1)
+		c.setRef(i)
+	}
+
+	err = c.Add(1 << 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Contains(1 << 40) {
+		t.Fatal("should have key")
+	}
+	if c.Stats().Evicted != 1 {
+		t.Fatal("evicted mismatched")
+	}
+}
+
+func TestCache_Close(t *testing.T) {
+
+	c, _ := NewCache(64)
+	_ = c.Add(0)
+	_ = c.Add(1)
+	c.Close()
+
+	if _, err := c.TryAdd(0); err != ErrIsClosed {
+		t.Fatal("TryAdd should return ErrIsClosed", err)
+	}
+	if c.Contains(0) || c.Contains(1) {
+		t.Fatal("Contains should be false")
+	}
+	if st := c.Stats(); st.Capacity != 0 || st.Usage != 0 {
+		t.Fatal("Stats should be zeros", st)
+	}
+}
@@ -0,0 +1,92 @@
+package u64
+
+// CountingSet is a multiset of unsigned 64-bit integers,
+// each key has a counter beside it (see kvSet).
+//
+// Add (Inc) increments the counter, Remove (Dec) decrements it,
+// key will be removed when its counter reaches zero.
+//
+// Count & Contains are wait-free as Set.Contains.
+type CountingSet struct {
+	kv *kvSet
+}
+
+// NewCountingSet creates a new CountingSet.
+// cap is the set capacity at the beginning, see New for details.
+func NewCountingSet(cap int) (*CountingSet, error) {
+	return &CountingSet{kv: newKVSet(cap)}, nil
+}
+
+// Add increments key's counter, key will be added if it's not existed.
+// Return nil if succeed.
+func (s *CountingSet) Add(key uint64) error {
+	_, err := s.Inc(key)
+	return err
+}
+
+// Inc increments key's counter, and returns the new count.
+func (s *CountingSet) Inc(key uint64) (n uint64, err error) {
+	err = s.kv.update(key, func(cnt uint64, _ bool) (uint64, bool) {
+		n = cnt + 1
+		return n, true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Remove decrements key's counter,
+// key will be removed when its counter reaches zero.
+func (s *CountingSet) Remove(key uint64) {
+	_ = s.Dec(key)
+}
+
+// Dec decrements key's counter, and returns the new count.
+// Return 0 if key isn't existed (or it's removed).
+func (s *CountingSet) Dec(key uint64) (n uint64) {
+	_ = s.kv.update(key, func(cnt uint64, ok bool) (uint64, bool) {
+		if !ok || cnt <= 1 {
+			n = 0
+			return 0, false
+		}
+		n = cnt - 1
+		return n, true
+	})
+	return n
+}
+
+// Count returns key's counter, 0 if key isn't existed.
+func (s *CountingSet) Count(key uint64) uint64 {
+	n, _ := s.kv.get(key)
+	return n
+}
+
+// Contains returns the key in set or not.
+func (s *CountingSet) Contains(key uint64) bool {
+	_, ok := s.kv.get(key)
+	return ok
+}
+
+// Range calls f sequentially for each key & its counter present in the CountingSet.
+// If f returns false, range stops the iteration.
+//
+// Range has the same consistency as Set.Range.
+func (s *CountingSet) Range(f func(key, cnt uint64) bool) {
+	s.kv.rangeKV(f)
+}
+
+// GetUsage returns CountingSet capacity & usage (count of distinct keys except 0).
+func (s *CountingSet) GetUsage() (total, usage int) {
+	return s.kv.getUsage()
+}
+
+// IsRunning returns CountingSet is running or not.
+func (s *CountingSet) IsRunning() bool {
+	return s.kv.IsRunning()
+}
+
+// Close closes CountingSet and release the resource.
+func (s *CountingSet) Close() {
+	s.kv.Close()
+}
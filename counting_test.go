@@ -0,0 +1,97 @@
+package u64
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCountingSet_IncDec(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	s, err := NewCountingSet(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 1024; i++ {
+		for j := 0; j <= i%4; j++ {
+			n, err := s.Inc(uint64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != uint64(j+1) {
+				t.Fatal("count mismatched", n)
+			}
+		}
+	}
+
+	for i := 0; i < 1024; i++ {
+		if s.Count(uint64(i)) != uint64(i%4+1) {
+			t.Fatal("count mismatched")
+		}
+	}
+
+	for i := 0; i < 1024; i++ {
+		for j := i % 4; j >= 0; j-- {
+			if !s.Contains(uint64(i)) {
+				t.Fatal("should have key")
+			}
+			if s.Dec(uint64(i)) != uint64(j) {
+				t.Fatal("count mismatched")
+			}
+		}
+		if s.Contains(uint64(i)) {
+			t.Fatal("should not have key")
+		}
+		if s.Dec(uint64(i)) != 0 {
+			t.Fatal("count mismatched")
+		}
+	}
+
+	_, usage := s.GetUsage()
+	if usage != 0 {
+		t.Fatal("usage mismatched", usage)
+	}
+}
+
+func TestCountingSet_Concurrent(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	s, err := NewCountingSet(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	gn := 4
+	wg := new(sync.WaitGroup)
+	wg.Add(gn)
+	for g := 0; g < gn; g++ {
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= 1024; i++ {
+				_ = s.Add(uint64(i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	cnt := 0
+	s.Range(func(key, n uint64) bool {
+		if n != uint64(gn) {
+			t.Fatal("count mismatched", key, n)
+		}
+		cnt++
+		return true
+	})
+	if cnt != 1024 {
+		t.Fatal("range mismatched", cnt)
+	}
+}
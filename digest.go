@@ -0,0 +1,71 @@
+package u64
+
+import (
+	"encoding/binary"
+
+	"github.com/templexxx/xxh3"
+)
+
+// digestSeed is the hash seed of the first digest lane, lanes use digestSeed+i.
+// They're different with tables', bloom filter's & ApproxSet's.
+const digestSeed = 4
+
+const digestLanes = 4
+
+// digest is an order-independent hash of keys:
+// each lane is the sum (mod 2^64) of xxh3 of keys with the lane's seed,
+// so adding & removing a key are O(1), and the same keys have the same digest in any order.
+//
+// It's not cryptographic: collisions are negligible for ordinary keys,
+// but keys could be chosen against it.
+type digest [digestLanes]uint64
+
+func (d *digest) add(key uint64) {
+	for i := range d {
+		d[i] += xxh3.HashU64(key, digestSeed+uint64(i))
+	}
+}
+
+func (d *digest) remove(key uint64) {
+	for i := range d {
+		d[i] -= xxh3.HashU64(key, digestSeed+uint64(i))
+	}
+}
+
+func (d digest) bytes() (b [32]byte) {
+	for i, v := range d {
+		binary.LittleEndian.PutUint64(b[i*8:], v)
+	}
+	return
+}
+
+// Digest returns the order-independent 256-bit digest of keys in Set,
+// two Sets have the same keys if their digests are equal (see DigestKeys for keys out of Set).
+//
+// It's maintained in Add/Remove, reading it is O(1) (under the write lock).
+// It returns the digest of empty set after Close.
+func (s *Set) Digest() [32]byte {
+
+restart:
+	if !s.lock() {
+		pause()
+		goto restart
+	}
+	d := s.digest
+	running := s.IsRunning()
+	s.unlock()
+
+	if !running {
+		return digest{}.bytes()
+	}
+	return d.bytes()
+}
+
+// DigestKeys returns the digest of keys as Set.Digest, keys must be unique.
+func DigestKeys(keys []uint64) [32]byte {
+	var d digest
+	for _, key := range keys {
+		d.add(key)
+	}
+	return d.bytes()
+}
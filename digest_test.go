@@ -0,0 +1,56 @@
+package u64
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSet_Digest(t *testing.T) {
+
+	keys := make([]uint64, 1024)
+	for i := range keys {
+		keys[i] = uint64(i)
+	}
+	keys[1] = ^uint64(0)
+
+	s0, _ := New(4096)
+	s1, _ := New(4096)
+	defer s0.Close()
+	defer s1.Close()
+	if s0.Digest() != ([32]byte{}) {
+		t.Fatal("empty set should have zero digest")
+	}
+
+	for _, k := range keys {
+		addKey(t, s0, k)
+	}
+	for _, i := range rand.Perm(len(keys)) {
+		addKey(t, s1, keys[i])
+		addKey(t, s1, keys[i]) // Existed, no change.
+	}
+	addKey(t, s1, 1<<40)
+	if s0.Digest() == s1.Digest() {
+		t.Fatal("different sets should have different digests")
+	}
+	s1.Remove(1 << 40)
+	s1.Remove(1 << 41) // Absent, no change.
+
+	want := DigestKeys(keys)
+	if s0.Digest() != want || s1.Digest() != want {
+		t.Fatal("digest mismatched")
+	}
+
+	b, err := BuildFrom(append(keys, keys[:10]...)) // Duplicated keys.
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if b.Digest() != want {
+		t.Fatal("digest of BuildFrom mismatched")
+	}
+
+	s0.Close()
+	if s0.Digest() != ([32]byte{}) {
+		t.Fatal("closed set should have zero digest")
+	}
+}
@@ -0,0 +1,142 @@
+package u64
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ExpiringSet is a Set which key has a deadline (TTL).
+//
+// Deadline is stored beside each key (see kvSet),
+// expired keys are treated as absent by Contains & Range at once,
+// and they'll be removed by a background reaper later.
+//
+// Contains is wait-free as Set.Contains, but it needs to get the clock.
+type ExpiringSet struct {
+	kv *kvSet
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewExpiringSet creates a new ExpiringSet.
+// cap is the set capacity at the beginning, see New for details.
+//
+// The reaper sweeps all tables every reapInterval,
+// if reapInterval <= 0, there is no reaper,
+// expired keys will only be removed by Remove or overwritten by Add.
+func NewExpiringSet(cap int, reapInterval time.Duration) (*ExpiringSet, error) {
+	s := &ExpiringSet{
+		kv:   newKVSet(cap),
+		done: make(chan struct{}),
+	}
+
+	if reapInterval > 0 {
+		s.wg.Add(1)
+		go s.reapLoop(reapInterval)
+	}
+	return s, nil
+}
+
+func (s *ExpiringSet) reapLoop(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.reap()
+		}
+	}
+}
+
+// reap removes all expired keys.
+func (s *ExpiringSet) reap() {
+	now := nowNano()
+	s.kv.sweep(func(_, deadline uint64) bool {
+		return int64(deadline) > now
+	})
+}
+
+// Add adds key into ExpiringSet, key will be expired after ttl.
+// If key is existed, its deadline will be reset.
+// Return nil if succeed.
+//
+// A ttl <= 0 makes key expired at once (but it still takes a slot until being reaped),
+// a too large ttl is saturated, key won't be expired.
+func (s *ExpiringSet) Add(key uint64, ttl time.Duration) error {
+	now := nowNano()
+	d := now + int64(ttl)
+	if ttl > 0 && d < now {
+		d = math.MaxInt64
+	}
+	deadline := uint64(d)
+	return s.kv.update(key, func(_ uint64, _ bool) (uint64, bool) {
+		return deadline, true
+	})
+}
+
+// Contains returns the key in set and not expired or not.
+func (s *ExpiringSet) Contains(key uint64) bool {
+	deadline, ok := s.kv.get(key)
+	return ok && int64(deadline) > nowNano()
+}
+
+// Deadline returns key's deadline if the key in set and not expired.
+func (s *ExpiringSet) Deadline(key uint64) (deadline time.Time, ok bool) {
+	d, ok := s.kv.get(key)
+	if !ok || int64(d) <= nowNano() {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(d)), true
+}
+
+// Remove removes key in ExpiringSet.
+func (s *ExpiringSet) Remove(key uint64) {
+	s.kv.remove(key)
+}
+
+// Range calls f sequentially for each unexpired key present in the ExpiringSet.
+// If f returns false, range stops the iteration.
+//
+// Range has the same consistency as Set.Range.
+func (s *ExpiringSet) Range(f func(key uint64) bool) {
+	now := nowNano()
+	s.kv.rangeKV(func(key, deadline uint64) bool {
+		if int64(deadline) <= now {
+			return true
+		}
+		return f(key)
+	})
+}
+
+// GetUsage returns ExpiringSet capacity & usage.
+// usage includes the expired keys which haven't been reaped.
+func (s *ExpiringSet) GetUsage() (total, usage int) {
+	return s.kv.getUsage()
+}
+
+// IsRunning returns ExpiringSet is running or not.
+func (s *ExpiringSet) IsRunning() bool {
+	return s.kv.IsRunning()
+}
+
+// Close stops the reaper and closes ExpiringSet.
+// It returns after the reaper exits.
+func (s *ExpiringSet) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		s.kv.Close()
+	})
+}
+
+func nowNano() int64 {
+	return time.Now().UnixNano()
+}
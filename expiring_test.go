@@ -0,0 +1,124 @@
+package u64
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExpiringSet_Contains(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	s, err := NewExpiringSet(1024, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 512; i++ {
+		err = s.Add(uint64(i), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Add(uint64(i+512), -time.Second) // Expired already.
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 512; i++ {
+		if !s.Contains(uint64(i)) {
+			t.Fatal("should have key")
+		}
+		if _, ok := s.Deadline(uint64(i)); !ok {
+			t.Fatal("should have deadline")
+		}
+		if s.Contains(uint64(i + 512)) {
+			t.Fatal("should be expired")
+		}
+	}
+
+	cnt := 0
+	s.Range(func(key uint64) bool {
+		if key >= 512 {
+			t.Fatal("should be expired")
+		}
+		cnt++
+		return true
+	})
+	if cnt != 512 {
+		t.Fatal("range mismatched", cnt)
+	}
+
+	// Reset deadline.
+	err = s.Add(512, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Contains(512) {
+		t.Fatal("should have key")
+	}
+
+	s.Remove(1)
+	if s.Contains(1) {
+		t.Fatal("should not have key")
+	}
+
+	// Deadline is saturated, not overflowed.
+	err = s.Add(513, time.Duration(math.MaxInt64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := s.Deadline(513); !ok || d.UnixNano() != math.MaxInt64 {
+		t.Fatal("deadline should be saturated", d, ok)
+	}
+}
+
+func TestExpiringSet_Reap(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	s, err := NewExpiringSet(1024, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 512; i++ {
+		err = s.Add(uint64(i), time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.Add(1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, usage := s.GetUsage()
+		if usage == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired keys should be reaped", usage)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !s.Contains(1024) {
+		t.Fatal("should have key")
+	}
+
+	s.Close()
+	if s.IsRunning() {
+		t.Fatal("should be closed")
+	}
+	if err = s.Add(1, time.Hour); err != ErrIsClosed {
+		t.Fatal("should be closed")
+	}
+}
@@ -0,0 +1,271 @@
+package u64
+
+import (
+	"encoding/binary"
+	"errors"
+	"unsafe"
+
+	"github.com/templexxx/xxh3"
+)
+
+// FrozenSet is an immutable Set optimized for reading.
+//
+// It's a bucketized cuckoo hash table: each bucket has 8 keys (a cache line, buckets are aligned to 64Bytes),
+// each key could be in one of its two buckets,
+// so Contains needs two cache lines at most (Set may need 8 or 16).
+// There is no atomic, no status & no second table.
+//
+// FrozenSet is safe for concurrent use because it's read-only.
+type FrozenSet struct {
+	seed    uint64
+	mask    uint64 // mask of bucket index.
+	cnt     int    // count of keys except 0.
+	hasZero bool
+	buckets []uint64
+}
+
+const (
+	frozenBucketSize = 8
+	// frozenMaxKicks is the maximum kicks of cuckoo inserting,
+	// if there is no place after that, trying to build again with another seed or more buckets.
+	frozenMaxKicks = 512
+	frozenMaxLoad  = 0.9
+	// frozenMaxTries is the maximum tries of building with different seeds in the same size.
+	frozenMaxTries = 8
+)
+
+var ErrInvalidFrozen = errors.New("invalid frozen set data")
+
+// Freeze returns a FrozenSet which has all keys in Set.
+//
+// Keys which are Added or Removed concurrently may be in FrozenSet or not,
+// see Range for more details.
+func (s *Set) Freeze() *FrozenSet {
+	keys := make([]uint64, 0, s.getCnt())
+	s.Range(func(key uint64) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return NewFrozenSet(keys)
+}
+
+// NewFrozenSet creates a FrozenSet with keys, duplicated keys are allowed.
+func NewFrozenSet(keys []uint64) *FrozenSet {
+
+	nb := nextPower2(uint64(float64(len(keys))/(frozenBucketSize*frozenMaxLoad)) + 1)
+	for {
+		for seed := uint64(0); seed < frozenMaxTries; seed++ {
+			if f, ok := buildFrozen(keys, nb, seed); ok {
+				return f
+			}
+		}
+		nb *= 2
+	}
+}
+
+func buildFrozen(keys []uint64, nb, seed uint64) (*FrozenSet, bool) {
+	f := &FrozenSet{
+		seed:    seed,
+		mask:    nb - 1,
+		buckets: makeFrozenBuckets(int(nb)),
+	}
+	for _, key := range keys {
+		if key == 0 {
+			f.hasZero = true
+			continue
+		}
+		if f.Contains(key) {
+			continue
+		}
+		if !f.insert(key) {
+			return nil, false
+		}
+		f.cnt++
+	}
+	return f, true
+}
+
+// makeFrozenBuckets makes nb buckets which are aligned to 64Bytes,
+// so each bucket is in one cache line.
+func makeFrozenBuckets(nb int) []uint64 {
+	p := make([]uint64, nb*frozenBucketSize+frozenBucketSize-1)
+	off := int(-uintptr(unsafe.Pointer(&p[0])) & 63 / 8)
+	return p[off : off+nb*frozenBucketSize]
+}
+
+// locate returns the two buckets' offsets of key.
+func (f *FrozenSet) locate(key uint64) (b0, b1 int) {
+	h := xxh3.HashU64(key, f.seed)
+	b0 = int(h&f.mask) * frozenBucketSize
+	b1 = int((h>>32)&f.mask) * frozenBucketSize
+	return
+}
+
+// insert inserts key by cuckoo kicking, returns false if failed.
+func (f *FrozenSet) insert(key uint64) bool {
+
+	b0, b1 := f.locate(key)
+	if f.insertBucket(b0, key) || f.insertBucket(b1, key) {
+		return true
+	}
+
+	b := b0
+	for i := 0; i < frozenMaxKicks; i++ {
+		// Kick out one key (not always the first one), and try to put it into its another bucket.
+		j := b + int((uint64(i)+key)%frozenBucketSize)
+		key, f.buckets[j] = f.buckets[j], key
+		k0, k1 := f.locate(key)
+		if k0 == b {
+			b = k1
+		} else {
+			b = k0
+		}
+		if f.insertBucket(b, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FrozenSet) insertBucket(b int, key uint64) bool {
+	for i := b; i < b+frozenBucketSize; i++ {
+		if f.buckets[i] == 0 {
+			f.buckets[i] = key
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns the key in set or not.
+func (f *FrozenSet) Contains(key uint64) bool {
+
+	if key == 0 {
+		return f.hasZero
+	}
+
+	b0, b1 := f.locate(key)
+	bkt := f.buckets[b0 : b0+frozenBucketSize]
+	for _, k := range bkt {
+		if k == key {
+			return true
+		}
+	}
+	bkt = f.buckets[b1 : b1+frozenBucketSize]
+	for _, k := range bkt {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the count of keys.
+func (f *FrozenSet) Len() int {
+	if f.hasZero {
+		return f.cnt + 1
+	}
+	return f.cnt
+}
+
+// Range calls f sequentially for each key present in the FrozenSet.
+// If fn returns false, range stops the iteration.
+func (f *FrozenSet) Range(fn func(key uint64) bool) {
+	for _, k := range f.buckets {
+		if k == 0 {
+			continue
+		}
+		if !fn(k) {
+			return
+		}
+	}
+	if f.hasZero {
+		fn(0)
+	}
+}
+
+// frozen binary format (little endian):
+// | magic(4) | version(1) | has_zero(1) | padding(2) | seed(8) | buckets_cnt(8) | buckets(buckets_cnt*8*8) |
+const (
+	frozenMagic      = 0x46343655 // "U64F"
+	frozenVersion    = 1
+	frozenHeaderSize = 24
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (f *FrozenSet) MarshalBinary() ([]byte, error) {
+	p := make([]byte, frozenHeaderSize+len(f.buckets)*8)
+	binary.LittleEndian.PutUint32(p[0:4], frozenMagic)
+	p[4] = frozenVersion
+	if f.hasZero {
+		p[5] = 1
+	}
+	binary.LittleEndian.PutUint64(p[8:16], f.seed)
+	binary.LittleEndian.PutUint64(p[16:24], f.mask+1)
+	b := p[frozenHeaderSize:]
+	for i, k := range f.buckets {
+		binary.LittleEndian.PutUint64(b[i*8:], k)
+	}
+	return p, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (f *FrozenSet) UnmarshalBinary(p []byte) error {
+
+	if len(p) < frozenHeaderSize ||
+		binary.LittleEndian.Uint32(p[0:4]) != frozenMagic || p[4] != frozenVersion {
+		return ErrInvalidFrozen
+	}
+
+	nb := binary.LittleEndian.Uint64(p[16:24])
+	size := uint64(len(p) - frozenHeaderSize)
+	// Checking nb before multiplying, a forged nb may overflow.
+	if nb == 0 || nb&(nb-1) != 0 || nb > size/(frozenBucketSize*8) || size != nb*frozenBucketSize*8 {
+		return ErrInvalidFrozen
+	}
+
+	g := &FrozenSet{
+		seed:    binary.LittleEndian.Uint64(p[8:16]),
+		mask:    nb - 1,
+		hasZero: p[5] == 1,
+		buckets: makeFrozenBuckets(int(nb)),
+	}
+	b := p[frozenHeaderSize:]
+	for i := range g.buckets {
+		g.buckets[i] = binary.LittleEndian.Uint64(b[i*8:])
+	}
+	// Each key must be in one of its two buckets & only once, or Contains may be wrong.
+	for i, key := range g.buckets {
+		if key == 0 {
+			continue
+		}
+		if !g.isValid(i, key) {
+			return ErrInvalidFrozen
+		}
+		g.cnt++
+	}
+
+	*f = *g
+	return nil
+}
+
+// isValid returns true if key at i is in one of its buckets,
+// and there is no other copy of it.
+func (f *FrozenSet) isValid(i int, key uint64) bool {
+	b0, b1 := f.locate(key)
+	b := i / frozenBucketSize * frozenBucketSize
+	if b != b0 && b != b1 {
+		return false
+	}
+	for _, bb := range [2]int{b0, b1} {
+		for j := bb; j < bb+frozenBucketSize; j++ {
+			if j != i && f.buckets[j] == key {
+				return false
+			}
+		}
+		if b0 == b1 {
+			break
+		}
+	}
+	return true
+}
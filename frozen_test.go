@@ -0,0 +1,165 @@
+package u64
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+func TestSet_Freeze(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	for _, n := range []int{0, 1, 7, 1024, 1 << 16} {
+		keys := generateKeys(n, randomKey)
+		s, _ := New(n * 2)
+		for _, key := range keys {
+			err := s.Add(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		f := s.Freeze()
+		if f.Len() != len(keys) {
+			t.Fatal("len mismatched", f.Len(), len(keys))
+		}
+		for _, key := range keys {
+			if !f.Contains(key) {
+				t.Fatal("should have key")
+			}
+		}
+		for i := 0; i < n; i++ {
+			key := uint64(n*4 + i + 1) // Bigger than all keys.
+			if f.Contains(key) {
+				t.Fatal("should not have key")
+			}
+		}
+
+		cnt := 0
+		f.Range(func(key uint64) bool {
+			if !s.Contains(key) {
+				t.Fatal("should not have key")
+			}
+			cnt++
+			return true
+		})
+		if cnt != f.Len() {
+			t.Fatal("range mismatched", cnt)
+		}
+	}
+}
+
+func TestFrozenSet_MarshalBinary(t *testing.T) {
+
+	keys := generateKeys(4096, randomKey)
+	keys = append(keys, 0, 1, 1)
+	f := NewFrozenSet(keys)
+
+	p, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f2 := new(FrozenSet)
+	err = f2.UnmarshalBinary(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f2.Len() != f.Len() {
+		t.Fatal("len mismatched", f2.Len(), f.Len())
+	}
+	for _, key := range keys {
+		if !f2.Contains(key) {
+			t.Fatal("should have key")
+		}
+	}
+
+	err = f2.UnmarshalBinary(p[:len(p)-1])
+	if err != ErrInvalidFrozen {
+		t.Fatal("should be invalid")
+	}
+
+	// Forged bucket count: nb*frozenBucketSize*8 wraps to 0.
+	forged := make([]byte, frozenHeaderSize)
+	copy(forged, p[:frozenHeaderSize])
+	binary.LittleEndian.PutUint64(forged[16:24], 1<<58)
+	err = f2.UnmarshalBinary(forged)
+	if err != ErrInvalidFrozen {
+		t.Fatal("should be invalid")
+	}
+}
+
+func TestFrozenSet_Aligned(t *testing.T) {
+	for n := 1; n < 64; n++ {
+		f := NewFrozenSet(generateKeys(n*8, randomKey))
+		if uintptr(unsafe.Pointer(&f.buckets[0]))&63 != 0 {
+			t.Fatal("buckets should be aligned to 64Bytes")
+		}
+	}
+}
+
+func TestFrozenSet_UnmarshalForged(t *testing.T) {
+
+	f := NewFrozenSet(generateKeys(1024, randomKey))
+	p, _ := f.MarshalBinary()
+
+	// forge copies the key at i to slot j.
+	forge := func(i, j int) []byte {
+		q := append([]byte(nil), p...)
+		copy(q[frozenHeaderSize+j*8:], p[frozenHeaderSize+i*8:frozenHeaderSize+i*8+8])
+		return q
+	}
+
+	misplaced, duplicated := false, false
+	for i, key := range f.buckets {
+		if key == 0 {
+			continue
+		}
+		b0, b1 := f.locate(key)
+		for j, k := range f.buckets {
+			if k != 0 {
+				continue
+			}
+			b := j / frozenBucketSize * frozenBucketSize
+			inBucket := b == b0 || b == b1
+			if (inBucket && duplicated) || (!inBucket && misplaced) {
+				continue
+			}
+			q := forge(i, j)
+			// Key is moved out of its buckets, or copied in them.
+			if !inBucket {
+				copy(q[frozenHeaderSize+i*8:], make([]byte, 8))
+				misplaced = true
+			} else {
+				duplicated = true
+			}
+			if err := new(FrozenSet).UnmarshalBinary(q); err != ErrInvalidFrozen {
+				t.Fatal("should be invalid", inBucket, err)
+			}
+		}
+		if misplaced && duplicated {
+			break
+		}
+	}
+	if !misplaced || !duplicated {
+		t.Fatal("no slot for forging")
+	}
+}
+
+func BenchmarkFrozenSet_Contains(b *testing.B) {
+	const n = 1 << 20
+	keys := generateKeys(n, sortKey)
+	f := NewFrozenSet(keys)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i uint64
+		for pb.Next() {
+			f.Contains(i % (n * 2))
+			i++
+		}
+	})
+}
@@ -1,8 +1,10 @@
 module github.com/templexxx/u64
 
-go 1.15
+go 1.18
 
 require (
 	github.com/templexxx/cpu v0.0.8-0.20201106075209-6651b1c9ec29
 	github.com/templexxx/xxh3 v0.0.2
 )
+
+require golang.org/x/sys v0.0.0-20200727154430-2d971f7391a4 // indirect
@@ -36,6 +36,9 @@ func backToOriginCap(c int) int {
 func (s *Set) getWritableTable() []uint64 {
 	idx := s.getWritableIdx()
 	p := atomic.LoadPointer(&s.cycle[idx])
+	if p == nil {
+		return nil
+	}
 	return *(*[]uint64)(p)
 }
 
@@ -0,0 +1,409 @@
+package u64
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// hopTable is the slot layout of a table in cycle (*T is stored in cycle).
+//
+// Set, kvSet, Set128 & ApproxSet share the neighbourhood probing & the two tables cycle (hop) by it,
+// only the entry (E) in a slot and how it's loaded & stored are different.
+// The zero E means the slot is free.
+type hopTable[E comparable, T any] interface {
+	*T
+	// slots returns the count of slots (including the extra virtual bucket).
+	slots() int
+	// home returns the hashed slot of e in table idx.
+	home(idx uint8, e E) int
+	// load loads the entry in slot i atomically.
+	load(i int) E
+	// store stores e into slot i atomically, it must be called under the write lock.
+	store(i int, e E)
+	// same returns true if a & b have the same key.
+	same(a, b E) bool
+	// next makes a table which has cap (origin capacity) for moving entries into.
+	next(s *Set, cap int) (*T, error)
+	// mem returns the bytes of a table which has cap (origin capacity).
+	mem(s *Set, cap int) int64
+	// retire frees the table after it's unreachable from cycle.
+	retire(s *Set)
+	// inserting is called before e is visible in table idx (e.g. for adding e into bloom filter).
+	inserting(s *Set, idx uint8, e E)
+}
+
+// hop is the neighbourhood probing & the two tables cycle on s.status & s.cycle,
+// tables in s.cycle are P.
+//
+// Key 0 (flagged by has_zero) isn't in tables, it's handled by callers.
+type hop[E comparable, T any, P hopTable[E, T]] struct {
+	s *Set
+}
+
+// table returns table idx, it's nil if there is no table.
+func (h hop[E, T, P]) table(idx uint8) P {
+	return P((*T)(atomic.LoadPointer(&h.s.cycle[idx])))
+}
+
+// neighbourhood returns the count of slots in slot's neighbourhood.
+func neighbourhood(slot, slotCnt int) int {
+	if slot+neighbour >= slotCnt {
+		return slotCnt - slot
+	}
+	return neighbour
+}
+
+// find returns the position of e's key in table idx (t) if has.
+func (h hop[E, T, P]) find(t P, idx uint8, e E) (has bool, pos int) {
+	if t == nil {
+		return false, 0
+	}
+	slot := t.home(idx, e)
+	n := neighbourhood(slot, t.slots())
+	for i := 0; i < n; i++ {
+		if t.same(t.load(slot+i), e) {
+			return true, slot + i
+		}
+	}
+	return false, 0
+}
+
+// put puts e into the writable table, Set must be locked.
+// If checkOther, the other table (which is being moved out) will be checked first.
+//
+// Returns ErrExisted if e's key is existed, ErrIsFull if there is no place in writable table.
+func (h hop[E, T, P]) put(e E, checkOther bool) error {
+	idx := h.s.getWritableIdx()
+	t := h.table(idx)
+	if t == nil {
+		return ErrIsClosed
+	}
+
+	// 0. Key may be still in the older table which is being expanded.
+	// expand itself is always locked, and it's moving keys out of the older table.
+	if checkOther {
+		if has, _ := h.find(h.table(idx^1), idx^1, e); has {
+			return ErrExisted
+		}
+	}
+	return h.insert(t, idx, e)
+}
+
+// insert inserts e into table idx (t), Set must be locked.
+func (h hop[E, T, P]) insert(t P, idx uint8, e E) error {
+
+	var zero E
+
+	// 1. Ensure key is unique. And try to find free slot within neighbourhood.
+	slotOff := neighbour // slotOff is the distance between avail slot from hashed slot.
+	slot := t.home(idx, e)
+	n := neighbourhood(slot, t.slots())
+	for i := 0; i < n; i++ {
+		x := t.load(slot + i)
+		if t.same(x, e) {
+			return ErrExisted
+		}
+		if x == zero && i < slotOff {
+			slotOff = i
+		}
+	}
+
+	t.inserting(h.s, idx, e)
+
+	// 2. Try to Add within neighbour.
+	if slotOff < neighbour {
+		t.store(slot+slotOff, e)
+		return nil
+	}
+
+	// 3. Linear probe to find an empty slot and swap.
+	j := slot + neighbour
+	for { // Closer and closer.
+		free, status := h.swap(t, idx, j)
+		if status == swapFull {
+			return ErrIsFull
+		}
+
+		if free-slot < neighbour {
+			t.store(free, e)
+			return nil
+		}
+		j = free
+	}
+}
+
+const (
+	swapOK = iota
+	swapFull
+)
+
+// swap swaps the free slot and the another one (closer to the hashed slot).
+// Return position & swapOK if find one.
+//
+// The entry is cleared before it's stored in the new slot,
+// so Range (DESC) won't visit it twice.
+func (h hop[E, T, P]) swap(t P, idx uint8, start int) (int, uint8) {
+
+	var zero E
+	slotCnt := t.slots()
+	for i := start; i < slotCnt; i++ {
+		if t.load(i) == zero { // Find a free one.
+			j := i - neighbour + 1
+			if j < 0 {
+				j = 0
+			}
+			for ; j < i; j++ { // Search start at the closet position.
+				x := t.load(j)
+				if i-t.home(idx, x) < neighbour {
+					t.store(j, zero)
+					t.store(i, x)
+					return j, swapOK
+				}
+			}
+			return 0, swapFull // Can't find slot for swapping. Table is full.
+		}
+	}
+	return 0, swapFull
+}
+
+// grow makes a new writable table with double capacity and puts e into it, Set must be locked.
+// Entries in the older table are moved to the new one by expand in background.
+func (h hop[E, T, P]) grow(e E) error {
+	s := h.s
+	if s.isScaling() {
+		// In practice, it's rare to have such fast adding.
+		// Which means the caller's speed if fast than 'sequential traverse'
+		return ErrAddTooFast
+	}
+
+	idx := s.getWritableIdx()
+	t := h.table(idx)
+	if t == nil {
+		return ErrIsClosed
+	}
+	oc := backToOriginCap(t.slots())
+	if oc*2 > MaxCap {
+		return ErrIsFull // Already MaxCap.
+	}
+	nt, err := h.scale(t, oc*2)
+	if err != nil {
+		return err
+	}
+	_ = h.insert(nt, idx^1, e) // First insert must be succeed.
+	s.background(func() { h.expand(idx) })
+	return nil
+}
+
+// scale makes a new writable table which has cap (origin capacity), Set must be locked.
+// t is the writable one before scaling, caller moves entries out of it by expand.
+func (h hop[E, T, P]) scale(t P, cap int) (P, error) {
+	s := h.s
+	mem := t.mem(s, cap)
+	if !s.reserveMem(mem) { // Both tables are alive during scaling.
+		return nil, ErrOverBudget
+	}
+	nt, err := t.next(s, cap)
+	if err != nil {
+		s.freeMem(mem)
+		return nil, err
+	}
+
+	s.scale()
+	next := s.getWritableIdx() ^ 1
+	atomic.StorePointer(&s.blooms[next], s.newTblBloom(cap))
+	atomic.StorePointer(&s.cycle[next], unsafe.Pointer(nt))
+	s.setWritable(next)
+	return nt, nil
+}
+
+// expand moves entries from table ri to the writable one (bigger or smaller), and sends events to observer.
+func (h hop[E, T, P]) expand(ri uint8) {
+	s := h.s
+	src := h.table(ri)
+	if src == nil {
+		return
+	}
+
+	wt := h.table(ri ^ 1)
+	e := ExpandEvent{OldCap: backToOriginCap(src.slots())}
+	if wt != nil {
+		e.NewCap = backToOriginCap(wt.slots())
+	}
+	s.onExpandStart(e)
+
+	start := time.Now()
+	e.Migrated, e.Err = h.migrate(ri, src)
+	switch e.Err {
+	case nil:
+		src.retire(s)
+	case ErrShrinkAborted:
+		wt.retire(s)
+	}
+	e.Duration = time.Since(start)
+
+	if e.Err == ErrIsSealed {
+		se := SealEvent{Usage: int(s.getCnt()), Err: ErrIsFull}
+		if t := h.table(s.getWritableIdx()); t != nil {
+			se.Cap = backToOriginCap(t.slots())
+		}
+		s.onSealed(se)
+	}
+	s.onExpandDone(e)
+}
+
+// migrate moves entries from table ri (src) to the writable one,
+// returns the count of moved entries & the reason of stopping before finishing.
+//
+// If the writable one is smaller (shrinking) and it's full (because of concurrent adding),
+// shrinking is aborted (see abortShrink) and ErrShrinkAborted is returned, Set won't be sealed.
+func (h hop[E, T, P]) migrate(ri uint8, src P) (int, error) {
+
+	var zero E
+	s := h.s
+	n, cnt, migrated := src.slots(), 0, 0
+	shrinking := false
+	if wt := h.table(ri ^ 1); wt != nil {
+		shrinking = wt.slots() < n
+	}
+	for i := 0; i < n; i++ {
+
+		if cnt >= 10 {
+			cnt = 0
+			runtime.Gosched() // Let potential 'func Add' run.
+		}
+
+	restart:
+		if !s.lock() {
+			pause()
+			goto restart
+		}
+
+		if !s.IsRunning() { // Checking under the lock, Close locks Set too.
+			s.unlock()
+			return migrated, ErrIsClosed
+		}
+
+		if x := src.load(i); x != zero {
+			err := h.put(x, false)
+			if err == ErrIsFull {
+				if shrinking {
+					if err = h.abortShrink(ri); err == nil {
+						err = ErrShrinkAborted
+					}
+					s.unlock()
+					return migrated, err
+				}
+				s.seal()
+				s.unlock()
+				return migrated, ErrIsSealed
+			}
+
+			// Only in ApproxSet: fingerprints which are different in src may be the same after rehashing,
+			// Add checks the older table, so keys can't be in both tables in others.
+			if err == ErrExisted {
+				s.delCnt()
+			}
+
+			cnt++
+			migrated++
+		}
+		if i == n-1 { // Last one is finished.
+			atomic.StorePointer(&s.cycle[ri], unsafe.Pointer(nil))
+			atomic.StorePointer(&s.blooms[ri], unsafe.Pointer(nil))
+			s.freeMem(src.mem(s, backToOriginCap(n)))
+			s.unScale()
+			s.unlock()
+			return migrated, nil
+		}
+		s.unlock()
+	}
+	return migrated, nil
+}
+
+// abortShrink makes table ri (the older one) writable again, and moves entries in the new one back.
+// Set must be locked.
+//
+// Table ri is still complete (migrate never clears it), only entries added during shrinking are moved,
+// and they're moved in one pass under the lock, so concurrent Adds can't fill table ri before that.
+// The new table is at most half of table ri, and it's unreachable from cycle after that.
+func (h hop[E, T, P]) abortShrink(ri uint8) error {
+
+	var zero E
+	s := h.s
+	wt := h.table(ri ^ 1)
+	s.setWritable(ri)
+	n := wt.slots()
+	for i := 0; i < n; i++ {
+		x := wt.load(i)
+		if x == zero {
+			continue
+		}
+		if err := h.put(x, false); err == ErrIsFull { // ErrExisted: it has been moved, ignore it.
+			s.seal()
+			return ErrIsSealed
+		}
+	}
+	atomic.StorePointer(&s.cycle[ri^1], unsafe.Pointer(nil))
+	atomic.StorePointer(&s.blooms[ri^1], unsafe.Pointer(nil))
+	s.freeMem(wt.mem(s, backToOriginCap(n)))
+	s.unScale()
+	return nil
+}
+
+// remove removes e's key in both tables, Set must be locked.
+// Return true if key was found.
+//
+// Key may be in both tables when it's being moved by expand,
+// so don't stop at the first one.
+func (h hop[E, T, P]) remove(e E) (removed bool) {
+	var zero E
+	for idx := uint8(0); idx < 2; idx++ {
+		t := h.table(idx)
+		if has, pos := h.find(t, idx, e); has {
+			t.store(pos, zero)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// scan calls f sequentially for each entry in tables,
+// it returns false if f returns false.
+// It has the same consistency as Set.Range.
+func (h hop[E, T, P]) scan(f func(e E) bool) bool {
+
+	var zero E
+	widx := h.s.getWritableIdx()
+	wt := h.table(widx)
+	nt := h.table(widx ^ 1)
+
+	if wt != nil {
+		for i := wt.slots() - 1; i >= 0; i-- { // DESC for avoiding visiting the same key twice caused by swap in Add process.
+			x := wt.load(i)
+			if x == zero {
+				continue
+			}
+			if !f(x) {
+				return false
+			}
+		}
+	}
+
+	if nt != nil {
+		for i := nt.slots() - 1; i >= 0; i-- {
+			x := nt.load(i)
+			if x == zero {
+				continue
+			}
+			if has, _ := h.find(wt, widx, x); has {
+				continue
+			}
+			if !f(x) {
+				return false
+			}
+		}
+	}
+	return true
+}
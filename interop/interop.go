@@ -0,0 +1,59 @@
+// Package interop reads/writes u64.Set in formats shared with other services:
+//
+// 1. Roaring64: the portable 64-bit Roaring bitmap serialization format,
+// see https://github.com/RoaringBitmap/RoaringFormatSpec
+//
+// 2. Sorted: delta+varint encoded sorted keys.
+//
+// Both formats are implemented here without extra dependencies.
+package interop
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"runtime"
+	"sort"
+
+	"github.com/templexxx/u64"
+)
+
+var ErrInvalidFormat = errors.New("invalid format")
+
+// sortedKeys returns all keys in s in ascending order.
+func sortedKeys(s *u64.Set) []uint64 {
+	_, usage := s.GetUsage()
+	keys := make([]uint64, 0, usage+1)
+	s.Range(func(key uint64) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// add adds key into s, waiting for expanding if it's too fast.
+func add(s *u64.Set, key uint64) error {
+	err := s.Add(key)
+	for err == u64.ErrAddTooFast {
+		runtime.Gosched()
+		err = s.Add(key)
+	}
+	return err
+}
+
+// byteReader returns r as io.ByteReader, wrapping it by bufio if it's not.
+func byteReader(r io.Reader) interface {
+	io.Reader
+	io.ByteReader
+} {
+	if br, ok := r.(interface {
+		io.Reader
+		io.ByteReader
+	}); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
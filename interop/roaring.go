@@ -0,0 +1,288 @@
+package interop
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math/bits"
+
+	"github.com/templexxx/u64"
+)
+
+// Roaring64 portable format (little endian):
+// | buckets_cnt(uint64) | bucket_0 | bucket_1 | ... |
+// bucket: | high_32_bits(uint32) | 32-bit Roaring bitmap |
+//
+// 32-bit Roaring bitmap:
+// | cookie | descriptive header | offset header | containers |
+// See https://github.com/RoaringBitmap/RoaringFormatSpec for details.
+const (
+	serialCookieNoRun = 12346
+	serialCookie      = 12347
+	noOffsetThreshold = 4
+
+	// arrayMaxCard is the maximum cardinality of array container,
+	// container which has more values is a bitmap container.
+	arrayMaxCard  = 4096
+	bitmapWords   = 1 << 16 / 64
+	containerSize = 1 << 16
+)
+
+// container is a 16-bit Roaring container: the high 16 bits & sorted low 16 bits.
+type container struct {
+	key    uint16
+	values []uint16
+}
+
+// WriteRoaring64 writes all keys in s in Roaring64 portable format.
+// Containers are array or bitmap, there is no run container.
+func WriteRoaring64(w io.Writer, s *u64.Set) error {
+	return WriteRoaring64Keys(w, sortedKeys(s))
+}
+
+// WriteRoaring64Keys writes keys in Roaring64 portable format,
+// keys must be unique and in ascending order.
+func WriteRoaring64Keys(w io.Writer, keys []uint64) error {
+	bw := bufio.NewWriter(w)
+
+	// Split keys by high 32 bits.
+	var buckets [][]uint64
+	for i := 0; i < len(keys); {
+		j := i + 1
+		for j < len(keys) && keys[j]>>32 == keys[i]>>32 {
+			j++
+		}
+		buckets = append(buckets, keys[i:j])
+		i = j
+	}
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(buckets)))
+	if _, err := bw.Write(buf[:8]); err != nil {
+		return err
+	}
+
+	for _, bkt := range buckets {
+		binary.LittleEndian.PutUint32(buf[:4], uint32(bkt[0]>>32))
+		if _, err := bw.Write(buf[:4]); err != nil {
+			return err
+		}
+		if err := writeRoaring32(bw, bkt); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeRoaring32 writes the low 32 bits of keys as a 32-bit Roaring bitmap without run container.
+func writeRoaring32(w io.Writer, keys []uint64) error {
+
+	var cs []container
+	for i := 0; i < len(keys); {
+		key := uint16(keys[i] >> 16)
+		c := container{key: key}
+		for ; i < len(keys) && uint16(keys[i]>>16) == key; i++ {
+			c.values = append(c.values, uint16(keys[i]))
+		}
+		cs = append(cs, c)
+	}
+
+	headerSize := 4 + 4 + 4*len(cs) + 4*len(cs)
+	p := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(p[0:], serialCookieNoRun)
+	binary.LittleEndian.PutUint32(p[4:], uint32(len(cs)))
+	off := headerSize
+	for i, c := range cs {
+		binary.LittleEndian.PutUint16(p[8+i*4:], c.key)
+		binary.LittleEndian.PutUint16(p[8+i*4+2:], uint16(len(c.values)-1))
+		binary.LittleEndian.PutUint32(p[8+len(cs)*4+i*4:], uint32(off))
+		off += containerBytes(len(c.values))
+	}
+	if _, err := w.Write(p); err != nil {
+		return err
+	}
+
+	for _, c := range cs {
+		if _, err := w.Write(encodeContainer(c.values)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containerBytes(card int) int {
+	if card > arrayMaxCard {
+		return bitmapWords * 8
+	}
+	return card * 2
+}
+
+func encodeContainer(values []uint16) []byte {
+	p := make([]byte, containerBytes(len(values)))
+	if len(values) > arrayMaxCard {
+		for _, v := range values {
+			p[v>>3] |= 1 << (v & 7) // Little endian words, bit i is in byte i/8.
+		}
+		return p
+	}
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(p[i*2:], v)
+	}
+	return p
+}
+
+// ReadRoaring64 reads keys in Roaring64 portable format and adds them into s.
+// s should have enough capacity, because adding in bulk is much faster than expanding.
+func ReadRoaring64(r io.Reader, s *u64.Set) error {
+	return ReadRoaring64Keys(r, func(key uint64) error {
+		return add(s, key)
+	})
+}
+
+// ReadRoaring64Keys reads keys in Roaring64 portable format and calls f for each key.
+func ReadRoaring64Keys(r io.Reader, f func(key uint64) error) error {
+
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:8]); err != nil {
+		return err
+	}
+	n := binary.LittleEndian.Uint64(buf[:8])
+	for i := uint64(0); i < n; i++ {
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return unexpectedEOF(err)
+		}
+		high := uint64(binary.LittleEndian.Uint32(buf[:4])) << 32
+		err := readRoaring32(r, func(low uint32) error {
+			return f(high | uint64(low))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRoaring32 reads a 32-bit Roaring bitmap (all container types) and calls f for each value.
+func readRoaring32(r io.Reader, f func(v uint32) error) error {
+
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	cookie := binary.LittleEndian.Uint32(buf[:])
+
+	var size int
+	var runs []byte // Bitset of run containers.
+	hasOffsets := true
+	switch {
+	case cookie == serialCookieNoRun:
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		size = int(binary.LittleEndian.Uint32(buf[:]))
+		if size > containerSize {
+			return ErrInvalidFormat
+		}
+	case cookie&0xffff == serialCookie:
+		size = int(cookie>>16) + 1
+		runs = make([]byte, (size+7)/8)
+		if _, err := io.ReadFull(r, runs); err != nil {
+			return unexpectedEOF(err)
+		}
+		hasOffsets = size >= noOffsetThreshold
+	default:
+		return ErrInvalidFormat
+	}
+
+	header := make([]byte, size*4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return unexpectedEOF(err)
+	}
+	if hasOffsets { // Containers are in order, offsets are useless for sequential reading.
+		if _, err := io.CopyN(io.Discard, r, int64(size*4)); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		high := uint32(binary.LittleEndian.Uint16(header[i*4:])) << 16
+		card := int(binary.LittleEndian.Uint16(header[i*4+2:])) + 1
+
+		var err error
+		switch {
+		case runs != nil && runs[i/8]&(1<<(i%8)) != 0:
+			err = readRunContainer(r, high, f)
+		case card > arrayMaxCard:
+			err = readBitmapContainer(r, high, f)
+		default:
+			err = readArrayContainer(r, high, card, f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readArrayContainer(r io.Reader, high uint32, card int, f func(v uint32) error) error {
+	p := make([]byte, card*2)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return unexpectedEOF(err)
+	}
+	for i := 0; i < card; i++ {
+		if err := f(high | uint32(binary.LittleEndian.Uint16(p[i*2:]))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readBitmapContainer(r io.Reader, high uint32, f func(v uint32) error) error {
+	p := make([]byte, bitmapWords*8)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return unexpectedEOF(err)
+	}
+	for i := 0; i < bitmapWords; i++ {
+		w := binary.LittleEndian.Uint64(p[i*8:])
+		for w != 0 {
+			v := uint32(i*64 + bits.TrailingZeros64(w))
+			if err := f(high | v); err != nil {
+				return err
+			}
+			w &= w - 1
+		}
+	}
+	return nil
+}
+
+func readRunContainer(r io.Reader, high uint32, f func(v uint32) error) error {
+	var buf [2]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	n := int(binary.LittleEndian.Uint16(buf[:]))
+	p := make([]byte, n*4)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return unexpectedEOF(err)
+	}
+	for i := 0; i < n; i++ {
+		start := uint32(binary.LittleEndian.Uint16(p[i*4:]))
+		length := uint32(binary.LittleEndian.Uint16(p[i*4+2:])) // length - 1 in fact.
+		if start+length >= containerSize {
+			return ErrInvalidFormat
+		}
+		for v := start; v <= start+length; v++ {
+			if err := f(high | v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package interop
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/templexxx/u64"
+)
+
+func TestRoaring64(t *testing.T) {
+
+	keys := []uint64{0, 1, 2, 3, 1 << 16, 1<<32 + 5, 1<<63 + 7, ^uint64(0)}
+	for i := 0; i < 5000; i++ { // A bitmap container.
+		keys = append(keys, 1<<40+uint64(i)*3)
+	}
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, rand.Uint64())
+	}
+
+	s, _ := u64.New(len(keys))
+	for _, key := range keys {
+		if err := add(s, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	err := WriteRoaring64(buf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2, _ := u64.New(len(keys))
+	err = ReadRoaring64(buf, s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameSet(t, s, s2)
+}
+
+func TestReadRoaring64_Format(t *testing.T) {
+
+	// {1, 2, 3, 100000, 1<<32 + 10 ... 1<<32 + 19}
+	p := []byte{
+		2, 0, 0, 0, 0, 0, 0, 0, // 2 buckets.
+
+		0, 0, 0, 0, // high 32 bits: 0.
+		0x3a, 0x30, 0, 0, // cookie: no run container.
+		2, 0, 0, 0, // 2 containers.
+		0, 0, 2, 0, 1, 0, 0, 0, // keys & cardinality - 1.
+		24, 0, 0, 0, 30, 0, 0, 0, // offsets.
+		1, 0, 2, 0, 3, 0, // array container.
+		0xa0, 0x86, // array container: 100000 - 65536.
+
+		1, 0, 0, 0, // high 32 bits: 1.
+		0x3b, 0x30, 0, 0, // cookie: has run container, 1 container.
+		1,          // run bitset.
+		0, 0, 9, 0, // keys & cardinality - 1.
+		1, 0, 10, 0, 9, 0, // run container: [10, 10+9].
+	}
+
+	var got []uint64
+	err := ReadRoaring64Keys(bytes.NewReader(p), func(key uint64) error {
+		got = append(got, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []uint64{1, 2, 3, 100000}
+	for i := uint64(10); i < 20; i++ {
+		exp = append(exp, 1<<32+i)
+	}
+	if len(got) != len(exp) {
+		t.Fatal("keys mismatched", got)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatal("keys mismatched", got)
+		}
+	}
+
+	// Writing the first bucket must be the same.
+	buf := new(bytes.Buffer)
+	err = WriteRoaring64Keys(buf, exp[:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes()[8:], p[8:8+4+8+8+8+8]) {
+		t.Fatal("format mismatched", buf.Bytes())
+	}
+
+	err = ReadRoaring64Keys(bytes.NewReader(p[:len(p)-1]), func(key uint64) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("should be failed")
+	}
+}
+
+func checkSameSet(t *testing.T, exp, got *u64.Set) {
+	_, eu := exp.GetUsage()
+	_, gu := got.GetUsage()
+	if eu != gu {
+		t.Fatal("usage mismatched", eu, gu)
+	}
+	exp.Range(func(key uint64) bool {
+		if !got.Contains(key) {
+			t.Fatal("should have key", key)
+		}
+		return true
+	})
+	if exp.Contains(0) != got.Contains(0) {
+		t.Fatal("zero mismatched")
+	}
+}
@@ -0,0 +1,80 @@
+package interop
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/templexxx/u64"
+)
+
+// Sorted format:
+// | count(uvarint) | delta_0(uvarint) | delta_1(uvarint) | ... |
+//
+// keys are in ascending order, delta_i = key_i - key_(i-1), key_(-1) = 0.
+
+// WriteSorted writes all keys in s in Sorted format.
+func WriteSorted(w io.Writer, s *u64.Set) error {
+	return WriteSortedKeys(w, sortedKeys(s))
+}
+
+// WriteSortedKeys writes keys in Sorted format,
+// keys must be unique and in ascending order.
+func WriteSortedKeys(w io.Writer, keys []uint64) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	n := binary.PutUvarint(buf, uint64(len(keys)))
+	if _, err := bw.Write(buf[:n]); err != nil {
+		return err
+	}
+
+	var prev uint64
+	for _, key := range keys {
+		n = binary.PutUvarint(buf, key-prev)
+		if _, err := bw.Write(buf[:n]); err != nil {
+			return err
+		}
+		prev = key
+	}
+	return bw.Flush()
+}
+
+// ReadSorted reads keys in Sorted format and adds them into s.
+// s should have enough capacity, because adding in bulk is much faster than expanding.
+// If r isn't an io.ByteReader, it will be buffered, and may be read beyond the data.
+func ReadSorted(r io.Reader, s *u64.Set) error {
+	return ReadSortedKeys(r, func(key uint64) error {
+		return add(s, key)
+	})
+}
+
+// ReadSortedKeys reads keys in Sorted format and calls f for each key in order.
+// If r isn't an io.ByteReader, it will be buffered, and may be read beyond the data.
+func ReadSortedKeys(r io.Reader, f func(key uint64) error) error {
+	br := byteReader(r)
+
+	cnt, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+
+	var key uint64
+	for i := uint64(0); i < cnt; i++ {
+		delta, err := binary.ReadUvarint(br)
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if i > 0 && (delta == 0 || key+delta < key) {
+			return ErrInvalidFormat // Not ascending.
+		}
+		key += delta
+		if err = f(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package interop
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/templexxx/u64"
+)
+
+func TestSorted(t *testing.T) {
+
+	s, _ := u64.New(4096)
+	keys := []uint64{0, 1, ^uint64(0)}
+	for i := 0; i < 2048; i++ {
+		keys = append(keys, rand.Uint64()>>uint(rand.Intn(64)))
+	}
+	for _, key := range keys {
+		if err := add(s, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	err := WriteSorted(buf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2, _ := u64.New(len(keys))
+	err = ReadSorted(buf, s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameSet(t, s, s2)
+}
+
+func TestReadSorted_Invalid(t *testing.T) {
+
+	buf := new(bytes.Buffer)
+	err := WriteSortedKeys(buf, []uint64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := buf.Bytes()
+	if !bytes.Equal(p, []byte{3, 1, 1, 1}) {
+		t.Fatal("format mismatched", p)
+	}
+
+	s, _ := u64.New(0)
+	err = ReadSorted(bytes.NewReader(p[:3]), s)
+	if err == nil {
+		t.Fatal("should be failed")
+	}
+
+	err = ReadSorted(bytes.NewReader([]byte{2, 1, 0}), s) // Not ascending.
+	if err != ErrInvalidFormat {
+		t.Fatal("should be invalid")
+	}
+}
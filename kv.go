@@ -0,0 +1,366 @@
+package u64
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// kvSet is a Set which has a value beside each key.
+//
+// It shares the status word & cycle with Set, only the table layout is different:
+// tables are made of pairs, tbl[2*i] is the key of slot i, tbl[2*i+1] is the value.
+// The neighbourhood probing & expanding are shared with Set (see hop).
+//
+// Set is a named field (not embedded) as Set128,
+// so the table methods of Set (Add, Contains, Range ...) which read a value as a key aren't reachable.
+type kvSet struct {
+	// set holds status & cycle, tables in cycle are pairs.
+	set Set
+	// zero is the value of key 0, key 0 is flagged by has_zero as Set.
+	zero uint64
+}
+
+// newKVSet creates a new kvSet, cap works as New.
+func newKVSet(cap int) *kvSet {
+
+	cap = int(nextPower2(uint64(cap)))
+
+	if cap < minCap {
+		cap = minCap
+	}
+	if cap > MaxCap {
+		cap = MaxCap
+	}
+
+	tbl := make(kvTable, calcTableCap(cap)*2)
+	s := new(kvSet)
+	s.set.status = createStatus()
+	s.set.reserveMem(tbl.mem(&s.set, cap))
+	s.set.cycle[0] = unsafe.Pointer(&tbl)
+	return s
+}
+
+// kvPair is the entry of kvSet tables.
+type kvPair struct {
+	key, val uint64
+}
+
+// kvTable is the table of kvSet, see kvSet for the layout.
+type kvTable []uint64
+
+// tables returns the hopscotch cycle of kvSet.
+func (s *kvSet) tables() hop[kvPair, kvTable, *kvTable] {
+	return hop[kvPair, kvTable, *kvTable]{s: &s.set}
+}
+
+func (t *kvTable) slots() int {
+	return len(*t) / 2
+}
+
+func (t *kvTable) home(idx uint8, p kvPair) int {
+	return getKVSlot(idx, len(*t)/2, p.key)
+}
+
+// load loads pair in slot i, it must be called under the write lock (see kvGet for reading without lock).
+func (t *kvTable) load(i int) kvPair {
+	k := atomic.LoadUint64(&(*t)[i*2])
+	if k == 0 {
+		return kvPair{}
+	}
+	return kvPair{key: k, val: atomic.LoadUint64(&(*t)[i*2+1])}
+}
+
+func (t *kvTable) store(i int, p kvPair) {
+	if p.key != 0 {
+		atomic.StoreUint64(&(*t)[i*2+1], p.val) // Value first, readers check key.
+	}
+	atomic.StoreUint64(&(*t)[i*2], p.key)
+}
+
+// same returns true if a & b have the same key, values are ignored.
+func (t *kvTable) same(a, b kvPair) bool {
+	return a.key == b.key
+}
+
+func (t *kvTable) next(_ *Set, cap int) (*kvTable, error) {
+	nt := make(kvTable, calcTableCap(cap)*2)
+	return &nt, nil
+}
+
+func (t *kvTable) mem(_ *Set, cap int) int64 {
+	return int64(calcTableCap(cap)) * 16
+}
+
+func (t *kvTable) retire(_ *Set) {}
+
+func (t *kvTable) inserting(_ *Set, _ uint8, _ kvPair) {}
+
+// get gets key's value, it's wait-free as Set.Contains.
+func (s *kvSet) get(key uint64) (val uint64, ok bool) {
+
+	sa := atomic.LoadUint64(&s.set.status)
+	if !bitOne(sa, 63) {
+		return 0, false
+	}
+
+	if key == 0 {
+		if !bitOne(sa, 58) {
+			return 0, false
+		}
+		return atomic.LoadUint64(&s.zero), true
+	}
+
+	// 1. Search writable table first, it has the newest value.
+	widx := getWritableIdxByStatus(sa)
+	val, ok = kvGet(widx, getTbl(&s.set, int(widx)), key)
+	if ok {
+		return
+	}
+	// 2. If is scaling, searching next table.
+	next := widx ^ 1
+	return kvGet(next, getTbl(&s.set, int(next)), key)
+}
+
+// kvGet gets key's value in tbl.
+func kvGet(idx uint8, tbl []uint64, key uint64) (val uint64, ok bool) {
+	if tbl == nil {
+		return 0, false
+	}
+
+	slotCnt := len(tbl) / 2
+	slot := getKVSlot(idx, slotCnt, key)
+	n := neighbour
+	if slot+neighbour >= slotCnt {
+		n = slotCnt - slot
+	}
+	for i := 0; i < n; i++ {
+		p := (slot + i) * 2
+		if atomic.LoadUint64(&tbl[p]) == key {
+			val = atomic.LoadUint64(&tbl[p+1])
+			// Check again, the slot may be reused by another key.
+			if atomic.LoadUint64(&tbl[p]) == key {
+				return val, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func getKVSlot(idx uint8, slotCnt int, key uint64) int {
+	h := calcHash(idx, key)
+	return int(h & (calcMask(uint32(slotCnt))))
+}
+
+// update updates key's value by f under the write lock.
+// f gets the present value (ok is false if key isn't existed),
+// and returns the new value & keep it or not.
+//
+// If keep is false, key will be removed.
+func (s *kvSet) update(key uint64, f func(val uint64, ok bool) (nv uint64, keep bool)) error {
+
+restart:
+	if !s.set.lock() {
+		pause()
+		goto restart
+	}
+
+	if !s.set.IsRunning() {
+		s.set.unlock()
+		return ErrIsClosed
+	}
+
+	if key == 0 {
+		ok := s.set.hasZero()
+		nv, keep := f(atomic.LoadUint64(&s.zero), ok)
+		if keep {
+			atomic.StoreUint64(&s.zero, nv)
+			s.set.addZero()
+		} else {
+			s.set.removeZero()
+		}
+		s.set.unlock()
+		return nil
+	}
+
+	// 1. Key may be in both tables when it's being moved by expand,
+	// the one in writable table is the newest.
+	h := s.tables()
+	widx := s.set.getWritableIdx()
+	for _, idx := range [2]uint8{widx, widx ^ 1} {
+		t := h.table(idx)
+		has, pos := h.find(t, idx, kvPair{key: key})
+		if !has {
+			continue
+		}
+		nv, keep := f(t.load(pos).val, true)
+		if keep {
+			t.store(pos, kvPair{key: key, val: nv})
+		} else {
+			s.removeLocked(key)
+		}
+		s.set.unlock()
+		return nil
+	}
+
+	nv, keep := f(0, false)
+	if !keep {
+		s.set.unlock()
+		return nil
+	}
+
+	// 2. Insert into writable table.
+	if s.set.isSealed() {
+		s.set.unlock()
+		return ErrIsSealed
+	}
+	p := kvPair{key: key, val: nv}
+	err := h.put(p, false) // Both tables are checked above.
+	if err == ErrIsFull {
+		// Last writable table is full, try to expand to new table.
+		err = h.grow(p)
+	}
+	if err != nil {
+		s.set.unlock()
+		return err
+	}
+	s.set.addCnt()
+	s.set.unlock()
+	return nil
+}
+
+// removeLocked removes key in both tables, Set must be locked.
+// Return true if key was found.
+func (s *kvSet) removeLocked(key uint64) (removed bool) {
+
+	if key == 0 {
+		removed = s.set.hasZero()
+		s.set.removeZero()
+		return removed
+	}
+
+	removed = s.tables().remove(kvPair{key: key})
+	if removed {
+		s.set.delCnt()
+	}
+	return removed
+}
+
+// remove removes key in kvSet.
+func (s *kvSet) remove(key uint64) (removed bool) {
+	if !s.set.IsRunning() {
+		return false
+	}
+
+restart:
+	if !s.set.lock() {
+		pause()
+		goto restart
+	}
+
+	if !s.set.IsRunning() {
+		s.set.unlock()
+		return false
+	}
+	removed = s.removeLocked(key)
+	s.set.unlock()
+	return removed
+}
+
+// rangeKV calls f sequentially for each pair present in the kvSet.
+// If f returns false, range stops the iteration.
+// It has the same consistency as Set.Range.
+func (s *kvSet) rangeKV(f func(key, val uint64) bool) {
+
+	if !s.set.IsRunning() {
+		return
+	}
+
+	if !s.tables().scan(func(p kvPair) bool { return f(p.key, p.val) }) {
+		return
+	}
+
+	if s.set.hasZero() && s.set.IsRunning() {
+		f(0, atomic.LoadUint64(&s.zero))
+	}
+}
+
+// sweep removes pairs which keep returns false,
+// it's incremental: locking one slot each time and yielding as expand.
+func (s *kvSet) sweep(keep func(key, val uint64) bool) {
+
+	cnt := 0
+	for ti := 0; ti < 2; ti++ {
+		src := getTbl(&s.set, ti)
+		for i := 0; i < len(src)/2; i++ {
+
+			if cnt >= 10 {
+				cnt = 0
+				runtime.Gosched() // Let potential update run.
+			}
+
+		restart:
+			if !s.set.lock() {
+				pause()
+				goto restart
+			}
+
+			if !s.set.IsRunning() {
+				s.set.unlock()
+				return
+			}
+
+			k := atomic.LoadUint64(&src[i*2])
+			if k != 0 {
+				cnt++
+				// Only check the newest one when key is in both tables.
+				widx := s.set.getWritableIdx()
+				if int(widx) == ti || !s.hasKV(widx, k) {
+					if !keep(k, atomic.LoadUint64(&src[i*2+1])) {
+						s.removeLocked(k)
+					}
+				}
+			}
+			s.set.unlock()
+		}
+	}
+
+restartZero:
+	if !s.set.lock() {
+		pause()
+		goto restartZero
+	}
+	if s.set.IsRunning() && s.set.hasZero() && !keep(0, atomic.LoadUint64(&s.zero)) {
+		s.set.removeZero()
+	}
+	s.set.unlock()
+}
+
+// hasKV returns true if key is in table idx.
+func (s *kvSet) hasKV(idx uint8, key uint64) bool {
+	h := s.tables()
+	has, _ := h.find(h.table(idx), idx, kvPair{key: key})
+	return has
+}
+
+// getUsage returns kvSet capacity & usage.
+func (s *kvSet) getUsage() (total, usage int) {
+	if !s.set.IsRunning() {
+		return 0, 0
+	}
+	tbl := getTbl(&s.set, int(s.set.getWritableIdx()))
+	if tbl != nil {
+		total = backToOriginCap(len(tbl) / 2)
+	}
+	return total, int(s.set.getCnt())
+}
+
+// IsRunning returns kvSet is running or not.
+func (s *kvSet) IsRunning() bool {
+	return s.set.IsRunning()
+}
+
+// Close closes kvSet and release the resource.
+func (s *kvSet) Close() {
+	s.set.Close()
+}
@@ -0,0 +1,179 @@
+package u64
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKVSet_Update(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	cnt := 3 << 11
+	s := newKVSet(4096) // Not enough capacity, must trigger expand.
+	defer s.Close()
+
+	for i := 0; i <= cnt; i++ {
+		err := s.update(uint64(i), func(_ uint64, ok bool) (uint64, bool) {
+			if ok {
+				t.Fatal("should not have key")
+			}
+			return uint64(i) * 2, true
+		})
+		for err == ErrAddTooFast { // Waiting for expanding.
+			time.Sleep(time.Millisecond)
+			err = s.update(uint64(i), func(_ uint64, _ bool) (uint64, bool) {
+				return uint64(i) * 2, true
+			})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i <= cnt; i++ {
+		err := s.update(uint64(i), func(v uint64, ok bool) (uint64, bool) {
+			if !ok || v != uint64(i)*2 {
+				t.Fatal("value mismatched")
+			}
+			return v + 1, true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i <= cnt; i++ {
+		v, ok := s.get(uint64(i))
+		if !ok || v != uint64(i)*2+1 {
+			t.Fatal("value mismatched", v, ok)
+		}
+	}
+
+	_, usage := s.getUsage()
+	if usage != cnt {
+		t.Fatal("usage mismatched", usage)
+	}
+
+	for i := 0; i <= cnt; i++ {
+		if !s.remove(uint64(i)) {
+			t.Fatal("should be removed")
+		}
+		if _, ok := s.get(uint64(i)); ok {
+			t.Fatal("should not have key")
+		}
+	}
+	_, usage = s.getUsage()
+	if usage != 0 {
+		t.Fatal("usage mismatched", usage)
+	}
+}
+
+func TestKVSet_Range(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	cnt := 3 << 11
+	s := newKVSet(4096) // Not enough capacity, must trigger expand.
+	defer s.Close()
+
+	for i := 0; i < cnt; i++ {
+		err := s.update(uint64(i), func(_ uint64, _ bool) (uint64, bool) {
+			return uint64(i) + 1, true
+		})
+		for err == ErrAddTooFast { // Waiting for expanding.
+			time.Sleep(time.Millisecond)
+			err = s.update(uint64(i), func(_ uint64, _ bool) (uint64, bool) {
+				return uint64(i) + 1, true
+			})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for s.set.isScaling() { // Keys may be moved by swap in expanding.
+		time.Sleep(time.Millisecond)
+	}
+
+	seen := make(map[uint64]bool, cnt)
+	s.rangeKV(func(k, v uint64) bool {
+		if seen[k] {
+			t.Fatalf("Range visited key %v twice", k)
+		}
+		if v != k+1 {
+			t.Fatal("value mismatched")
+		}
+		seen[k] = true
+		return true
+	})
+	if len(seen) != cnt {
+		t.Fatalf("Range visited %v elements of %v-element Set", len(seen), cnt)
+	}
+}
+
+func TestKVSet_Sweep(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	cnt := 1 << 12
+	s := newKVSet(cnt)
+	defer s.Close()
+
+	for i := 0; i < cnt; i++ {
+		err := s.update(uint64(i), func(_ uint64, _ bool) (uint64, bool) {
+			return uint64(i), true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.sweep(func(_, v uint64) bool {
+		return v%2 == 0
+	})
+
+	for i := 0; i < cnt; i++ {
+		_, ok := s.get(uint64(i))
+		if ok != (i%2 == 0) {
+			t.Fatal("sweep mismatched", i)
+		}
+	}
+}
+
+func TestKVSet_CloseRace(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	s := newKVSet(64) // Small one, expand is running when closing.
+	set := func(val uint64, _ bool) (uint64, bool) { return val + 1, true }
+	raceClose([]func(i int){
+		func(i int) { _ = s.update(uint64(i), set) },
+		func(i int) { s.get(uint64(i)) },
+		func(i int) { s.remove(uint64(i)) },
+		func(i int) { s.getUsage() },
+		func(i int) { s.rangeKV(func(key, _ uint64) bool { return key < uint64(i) }) },
+	}, s.Close)
+
+	if err := s.update(0, set); err != ErrIsClosed {
+		t.Fatal("update should return ErrIsClosed", err)
+	}
+	if _, ok := s.get(1); ok {
+		t.Fatal("get should be false")
+	}
+	if total, usage := s.getUsage(); total != 0 || usage != 0 {
+		t.Fatal("getUsage should be zeros", total, usage)
+	}
+	s.rangeKV(func(_, _ uint64) bool {
+		t.Fatal("rangeKV should do nothing")
+		return false
+	})
+}
@@ -0,0 +1,74 @@
+package u64
+
+import "time"
+
+// Observer receives events of Set, it's registered by WithObserver.
+//
+// Events are sent from the background goroutine which moves keys (started by Add for expanding,
+// or by Shrink), without the write lock. Set is only sealed when the moving of expanding fails,
+// so all scaling & sealing are reported.
+//
+// Methods should return quickly (e.g. logging, counting) or they'll delay the moving.
+// They must not call Set.Close: Close waits for the goroutine which is calling them (deadlock).
+type Observer interface {
+	// OnExpandStart is called when expanding starts (shrinking too, NewCap is smaller),
+	// the new table is writable, and keys in the old one are going to be moved.
+	OnExpandStart(e ExpandEvent)
+	// OnExpandDone is called when expanding stops,
+	// e.Err is nil if all keys are moved.
+	OnExpandDone(e ExpandEvent)
+	// OnSealed is called when Set is sealed, Add will return ErrIsSealed after that.
+	OnSealed(e SealEvent)
+}
+
+// ExpandEvent is the event of expanding.
+type ExpandEvent struct {
+	// OldCap is the capacity of the old table.
+	OldCap int
+	// NewCap is the capacity of the new table.
+	NewCap int
+	// Migrated is the count of keys moved from the old table,
+	// only in OnExpandDone.
+	Migrated int
+	// Duration is the time cost of expanding,
+	// only in OnExpandDone.
+	Duration time.Duration
+	// Err is the reason of stopping expanding before finishing,
+	// ErrIsSealed, ErrIsClosed or ErrShrinkAborted, only in OnExpandDone.
+	Err error
+}
+
+// SealEvent is the event of sealing.
+type SealEvent struct {
+	// Cap is the capacity of writable table.
+	Cap int
+	// Usage is the count of keys.
+	Usage int
+	// Err is the reason of sealing.
+	Err error
+}
+
+// WithObserver registers o for receiving events of Set.
+func WithObserver(o Observer) Option {
+	return func(opts *options) {
+		opts.observer = o
+	}
+}
+
+func (s *Set) onExpandStart(e ExpandEvent) {
+	if s.opts.observer != nil {
+		s.opts.observer.OnExpandStart(e)
+	}
+}
+
+func (s *Set) onExpandDone(e ExpandEvent) {
+	if s.opts.observer != nil {
+		s.opts.observer.OnExpandDone(e)
+	}
+}
+
+func (s *Set) onSealed(e SealEvent) {
+	if s.opts.observer != nil {
+		s.opts.observer.OnSealed(e)
+	}
+}
@@ -0,0 +1,54 @@
+package u64
+
+// Option is the option of creating Set.
+type Option func(*options)
+
+type options struct {
+	// bloomBits is the bits per key of bloom filter,
+	// 0 means no bloom filter.
+	bloomBits int
+	// buildWorkers is the count of goroutines in BuildFrom,
+	// 0 means runtime.GOMAXPROCS(0).
+	buildWorkers int
+	// observer receives events, nil means no observer.
+	observer Observer
+	// budget limits the memory of tables, nil means no limit.
+	budget *Budget
+	// alloc allocates tables, nil means the Go heap.
+	alloc Allocator
+}
+
+func makeOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithBloom enables a blocked Bloom filter in front of each table,
+// bitsPerKey is the bits for each key of table capacity (e.g. 10 for about 1% false positive rate).
+//
+// Contains could short-circuit most misses with one cache line,
+// but Add will be a bit slower and each key needs bitsPerKey/8 Bytes more.
+// Bloom filter can't delete, so the false positive rate will grow up with Remove,
+// it will be rebuilt when expanding.
+func WithBloom(bitsPerKey int) Option {
+	return func(o *options) {
+		if bitsPerKey < 0 {
+			bitsPerKey = 0
+		}
+		o.bloomBits = bitsPerKey
+	}
+}
+
+// WithBuildWorkers sets the count of goroutines in BuildFrom,
+// default is runtime.GOMAXPROCS(0).
+func WithBuildWorkers(n int) Option {
+	return func(o *options) {
+		if n < 0 {
+			n = 0
+		}
+		o.buildWorkers = n
+	}
+}
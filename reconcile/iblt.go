@@ -0,0 +1,214 @@
+package reconcile
+
+import (
+	"encoding/binary"
+
+	"github.com/templexxx/xxh3"
+)
+
+// ibltHashes is the count of cells which a key is in,
+// cells are split into ibltHashes parts, each key is in one cell of each part.
+const ibltHashes = 3
+
+// cellSize is the encoded size of a cell.
+const cellSize = 24
+
+// cell is an IBLT cell.
+type cell struct {
+	// count is the count of inserted keys minus deleted keys.
+	count int64
+	// keySum is the XOR of keys.
+	keySum uint64
+	// hashSum is the XOR of keys' checkHash.
+	hashSum uint64
+}
+
+func (c *cell) update(key, check uint64, delta int64) {
+	c.count += delta
+	c.keySum ^= key
+	c.hashSum ^= check
+}
+
+// pure returns true if there is only one key in cell (inserted or deleted).
+func (c *cell) pure() bool {
+	return (c.count == 1 || c.count == -1) && c.hashSum == checkHash(c.keySum)
+}
+
+func (c *cell) empty() bool {
+	return c.count == 0 && c.keySum == 0 && c.hashSum == 0
+}
+
+// IBLT is an Invertible Bloom Lookup Table of uint64 keys.
+//
+// The difference of two IBLTs (made by Subtract) could be decoded into keys only in either one,
+// if the count of them is small enough for the size (see CellsFor).
+//
+// IBLT isn't safe for concurrent use.
+type IBLT struct {
+	cells []cell
+}
+
+// NewIBLT creates an empty IBLT which has at least cells cells.
+func NewIBLT(cells int) *IBLT {
+	if cells < ibltHashes {
+		cells = ibltHashes
+	}
+	cells = (cells + ibltHashes - 1) / ibltHashes * ibltHashes
+	return &IBLT{cells: make([]cell, cells)}
+}
+
+// Cells returns the count of cells.
+func (t *IBLT) Cells() int {
+	return len(t.cells)
+}
+
+// index returns the key's cell in the i-th part.
+func (t *IBLT) index(key uint64, i int) int {
+	m := uint64(len(t.cells) / ibltHashes)
+	return i*int(m) + int(xxh3.HashU64(key, cellSeed+uint64(i))%m)
+}
+
+func (t *IBLT) update(key uint64, delta int64) {
+	check := checkHash(key)
+	for i := 0; i < ibltHashes; i++ {
+		t.cells[t.index(key, i)].update(key, check, delta)
+	}
+}
+
+// Insert inserts key.
+func (t *IBLT) Insert(key uint64) {
+	t.update(key, 1)
+}
+
+// Delete deletes key, key should be inserted before.
+func (t *IBLT) Delete(key uint64) {
+	t.update(key, -1)
+}
+
+// Subtract subtracts peer from t, then t is the difference:
+// keys only in t are inserted, keys only in peer are deleted.
+func (t *IBLT) Subtract(peer *IBLT) error {
+	if len(t.cells) != len(peer.cells) {
+		return ErrSizeMismatch
+	}
+	for i := range t.cells {
+		c, p := &t.cells[i], &peer.cells[i]
+		c.count -= p.count
+		c.keySum ^= p.keySum
+		c.hashSum ^= p.hashSum
+	}
+	return nil
+}
+
+// Decode lists the inserted keys (only in local after Subtract) & the deleted keys (only in peer),
+// t isn't modified.
+//
+// It returns ErrDecode with the keys which have been decoded if it can't list all of them.
+// A forged or broken peer can't make it loop: a pure cell is peeled only if it's one of the key's cells,
+// a key won't be peeled twice with the same sign, and there are at most len(cells)*ibltHashes peels.
+func (t *IBLT) Decode() (inserted, deleted []uint64, err error) {
+
+	cells := make([]cell, len(t.cells))
+	copy(cells, t.cells)
+	d := &IBLT{cells: cells}
+
+	stack := make([]int, 0, len(cells))
+	for i := range cells {
+		if cells[i].pure() {
+			stack = append(stack, i)
+		}
+	}
+
+	peeled := make(map[uint64]int64) // Key -> sign of its last peeling.
+	for n := 0; len(stack) > 0; {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		c := cells[i]
+		if !c.pure() { // It's peeled by another key.
+			continue
+		}
+		key := c.keySum
+		if !d.isIndex(key, i) || peeled[key] == c.count {
+			continue // Broken cell, it's left and fails the checking below.
+		}
+		if n++; n > len(cells)*ibltHashes {
+			return inserted, deleted, ErrDecode
+		}
+		peeled[key] = c.count
+		if c.count == 1 {
+			inserted = append(inserted, key)
+		} else {
+			deleted = append(deleted, key)
+		}
+
+		d.update(key, -c.count)
+		for j := 0; j < ibltHashes; j++ {
+			if k := d.index(key, j); cells[k].pure() {
+				stack = append(stack, k)
+			}
+		}
+	}
+
+	for i := range cells {
+		if !cells[i].empty() {
+			return inserted, deleted, ErrDecode
+		}
+	}
+	return inserted, deleted, nil
+}
+
+// isIndex returns true if cell i is one of key's cells.
+func (t *IBLT) isIndex(key uint64, i int) bool {
+	for j := 0; j < ibltHashes; j++ {
+		if t.index(key, j) == i {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalBinary encodes t:
+// | cells_cnt(uint32) | cell_0 | cell_1 | ... |
+// cell: | count(int64) | key_sum(uint64) | hash_sum(uint64) |, all in little endian.
+func (t *IBLT) MarshalBinary() ([]byte, error) {
+	p := make([]byte, 4+len(t.cells)*cellSize)
+	binary.LittleEndian.PutUint32(p, uint32(len(t.cells)))
+	b := p[4:]
+	for i := range t.cells {
+		c := &t.cells[i]
+		binary.LittleEndian.PutUint64(b, uint64(c.count))
+		binary.LittleEndian.PutUint64(b[8:], c.keySum)
+		binary.LittleEndian.PutUint64(b[16:], c.hashSum)
+		b = b[cellSize:]
+	}
+	return p, nil
+}
+
+// UnmarshalBinary decodes data made by MarshalBinary into t.
+func (t *IBLT) UnmarshalBinary(data []byte) error {
+	_, err := t.unmarshal(data)
+	return err
+}
+
+// unmarshal decodes an IBLT in the front of data, returns the left bytes.
+func (t *IBLT) unmarshal(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, ErrInvalidFormat
+	}
+	n := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+	if n < ibltHashes || n%ibltHashes != 0 || len(data) < n*cellSize {
+		return nil, ErrInvalidFormat
+	}
+	t.cells = make([]cell, n)
+	for i := range t.cells {
+		t.cells[i] = cell{
+			count:   int64(binary.LittleEndian.Uint64(data)),
+			keySum:  binary.LittleEndian.Uint64(data[8:]),
+			hashSum: binary.LittleEndian.Uint64(data[16:]),
+		}
+		data = data[cellSize:]
+	}
+	return data, nil
+}
@@ -0,0 +1,76 @@
+// Package reconcile finds the symmetric difference of two u64.Sets on different nodes
+// by exchanging O(diff) data, with Invertible Bloom Lookup Tables (IBLT) & a strata estimator.
+//
+// Typical flow between node A & B (e.g. after their u64.Set.Digest mismatched):
+//
+// 1. A sends NewEstimatorFrom(a) to B (fixed size, about 60KiB).
+//
+// 2. B estimates the difference: d := NewEstimatorFrom(b).Estimate(estA), and asks A for an IBLT of CellsFor(d).
+//
+// 3. A sends NewIBLTFrom(a, cells), B subtracts it from NewIBLTFrom(b, cells) and decodes:
+// keys only in B & keys only in A.
+// If decoding fails (the estimate was too small), retry with double cells.
+//
+// See "What's the Difference? Efficient Set Reconciliation without Prior Context" (Eppstein et al.) for details.
+package reconcile
+
+import (
+	"errors"
+
+	"github.com/templexxx/u64"
+	"github.com/templexxx/xxh3"
+)
+
+var (
+	// ErrDecode is returned when IBLT can't be decoded entirely (too many differences for its size).
+	ErrDecode = errors.New("iblt decode failed")
+	// ErrSizeMismatch is returned when subtracting sketches which have different sizes.
+	ErrSizeMismatch = errors.New("sketch size mismatch")
+	// ErrInvalidFormat is returned by UnmarshalBinary for broken data.
+	ErrInvalidFormat = errors.New("invalid format")
+)
+
+// Hash seeds, they're part of the format (peers must use the same).
+const (
+	checkSeed  = 16 // Hash for verifying pure cells.
+	strataSeed = 17 // Hash for choosing stratum.
+	cellSeed   = 18 // Hashes for choosing cells, cellSeed+i for the i-th one.
+)
+
+func checkHash(key uint64) uint64 {
+	return xxh3.HashU64(key, checkSeed)
+}
+
+// NewIBLTFrom creates an IBLT which has (at least) cells cells and all keys in s.
+//
+// Keys are taken by s.Range without copying or locking, writers aren't blocked.
+// It's a fuzzy view if s is being written, the keys written during that are differences
+// which will be found in the next reconciliation.
+func NewIBLTFrom(s *u64.Set, cells int) *IBLT {
+	t := NewIBLT(cells)
+	s.Range(func(key uint64) bool {
+		t.Insert(key)
+		return true
+	})
+	return t
+}
+
+// NewEstimatorFrom creates an Estimator which has all keys in s.
+// Keys are taken by s.Range as NewIBLTFrom.
+func NewEstimatorFrom(s *u64.Set) *Estimator {
+	e := NewEstimator()
+	s.Range(func(key uint64) bool {
+		e.Insert(key)
+		return true
+	})
+	return e
+}
+
+// CellsFor returns the IBLT cells for decoding diff differences,
+// decoding fails in less than 1% cases (e.g. keys share all their cells), retry with double cells then.
+func CellsFor(diff int) int {
+	if diff < 0 {
+		diff = 0
+	}
+	return diff*2 + 64 // 2x is above the peeling threshold of 3 hashes (about 1.23x), 64 is for small diff.
+}
@@ -0,0 +1,298 @@
+package reconcile
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/templexxx/u64"
+)
+
+// makeKeys makes common keys & keys only in a / b.
+func makeKeys(rnd *rand.Rand, common, onlyA, onlyB int) (c, a, b []uint64) {
+	seen := map[uint64]bool{}
+	gen := func(n int) []uint64 {
+		keys := make([]uint64, 0, n)
+		for len(keys) < n {
+			k := rnd.Uint64()
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+	return gen(common), gen(onlyA), gen(onlyB)
+}
+
+func sortKeys(keys []uint64) []uint64 {
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func sameKeys(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = sortKeys(append([]uint64(nil), a...)), sortKeys(append([]uint64(nil), b...))
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIBLT(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(1))
+	common, onlyA, onlyB := makeKeys(rnd, 10000, 60, 40)
+	onlyA = append(onlyA, 0) // Key 0 works too.
+
+	ta, tb := NewIBLT(CellsFor(101)), NewIBLT(CellsFor(101))
+	for _, k := range common {
+		ta.Insert(k)
+		tb.Insert(k)
+	}
+	for _, k := range onlyA {
+		ta.Insert(k)
+	}
+	for _, k := range onlyB {
+		tb.Insert(k)
+	}
+
+	p, _ := tb.MarshalBinary() // Sent by b.
+	peer := new(IBLT)
+	if err := peer.UnmarshalBinary(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.Subtract(peer); err != nil {
+		t.Fatal(err)
+	}
+	inserted, deleted, err := ta.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameKeys(inserted, onlyA) || !sameKeys(deleted, onlyB) {
+		t.Fatal("difference mismatched")
+	}
+
+	if err = ta.Subtract(NewIBLT(ta.Cells() + 3)); err != ErrSizeMismatch {
+		t.Fatal("should be size mismatch", err)
+	}
+	if err = peer.UnmarshalBinary(p[:len(p)-1]); err != ErrInvalidFormat {
+		t.Fatal("should be invalid", err)
+	}
+}
+
+func TestIBLT_DecodeFailed(t *testing.T) {
+
+	tbl := NewIBLT(30)
+	for i := uint64(0); i < 100; i++ {
+		tbl.Insert(i)
+	}
+	inserted, _, err := tbl.Decode()
+	if err != ErrDecode {
+		t.Fatal("should be failed", err)
+	}
+	if len(inserted) >= 100 {
+		t.Fatal("should be partial")
+	}
+}
+
+// forgeIBLT makes a sketch which has key only in its last cell (index(key, 2)),
+// peeling key flips the cells of key between pure & empty.
+func forgeIBLT(t *testing.T, cells int, key uint64) []byte {
+	t.Helper()
+
+	tbl := NewIBLT(cells)
+	p, _ := tbl.MarshalBinary()
+	b := p[4+tbl.index(key, 2)*cellSize:]
+	binary.LittleEndian.PutUint64(b, 1)
+	binary.LittleEndian.PutUint64(b[8:], key)
+	binary.LittleEndian.PutUint64(b[16:], checkHash(key))
+	return p
+}
+
+func TestIBLT_DecodeForged(t *testing.T) {
+
+	peer := new(IBLT)
+	if err := peer.UnmarshalBinary(forgeIBLT(t, 30, 12345)); err != nil {
+		t.Fatal(err)
+	}
+	local := NewIBLT(30)
+	if err := local.Subtract(peer); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := local.Decode()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != ErrDecode {
+			t.Fatal("should be failed", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("decode should return")
+	}
+
+	// Estimator decodes peer's strata too.
+	ep := NewEstimator()
+	if err := ep.strata[strataCnt-1].UnmarshalBinary(forgeIBLT(t, strataCells, 12345)); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := ep.MarshalBinary()
+	if err := ep.UnmarshalBinary(p); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, err := NewEstimator().Estimate(ep)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("estimate should return")
+	}
+}
+
+func TestEstimator(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(2))
+	for _, d := range []int{0, 10, 100, 1000, 10000} {
+		common, onlyA, onlyB := makeKeys(rnd, 20000, d/2, d-d/2)
+		ea, eb := NewEstimator(), NewEstimator()
+		for _, k := range common {
+			ea.Insert(k)
+			eb.Insert(k)
+		}
+		for _, k := range onlyA {
+			ea.Insert(k)
+		}
+		for _, k := range onlyB {
+			eb.Insert(k)
+		}
+
+		p, _ := eb.MarshalBinary()
+		peer := new(Estimator)
+		if err := peer.UnmarshalBinary(p); err != nil {
+			t.Fatal(err)
+		}
+		est, err := ea.Estimate(peer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if est < d/3 || est > d*3 {
+			t.Fatalf("estimate of %d is %d", d, est)
+		}
+	}
+}
+
+func TestEstimator_TooLarge(t *testing.T) {
+
+	ea, eb := NewEstimator(), NewEstimator()
+	for i := 0; i < 1000; i++ { // Too many differences in the sparsest stratum.
+		ea.strata[strataCnt-1].Insert(uint64(i))
+	}
+	est, err := ea.Estimate(eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est < 1<<strataCnt {
+		t.Fatal("estimate should be a large lower bound", est)
+	}
+
+	eb.strata[3] = NewIBLT(strataCells * 2)
+	if _, err = NewEstimator().Estimate(eb); err != ErrSizeMismatch {
+		t.Fatal("should be size mismatch", err)
+	}
+
+	p, _ := eb.MarshalBinary()
+	if err = new(Estimator).UnmarshalBinary(p); err != ErrInvalidFormat {
+		t.Fatal("should be invalid", err)
+	}
+}
+
+func addKey(t *testing.T, s *u64.Set, key uint64) {
+	t.Helper()
+	err := s.Add(key)
+	for err == u64.ErrAddTooFast {
+		runtime.Gosched()
+		err = s.Add(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(3))
+	common, onlyA, onlyB := makeKeys(rnd, 20000, 300, 200)
+
+	a, _ := u64.New(32768)
+	b, _ := u64.New(32768)
+	defer a.Close()
+	defer b.Close()
+	for _, k := range common {
+		addKey(t, a, k)
+		addKey(t, b, k)
+	}
+	for _, k := range onlyA {
+		addKey(t, a, k)
+	}
+	for _, k := range onlyB {
+		addKey(t, b, k)
+	}
+	if a.Digest() == b.Digest() {
+		t.Fatal("digests should be different")
+	}
+
+	// B estimates by A's estimator, and asks A for an IBLT.
+	d, err := NewEstimatorFrom(b).Estimate(NewEstimatorFrom(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells := CellsFor(d)
+	for {
+		tb := NewIBLTFrom(b, cells)
+		if err = tb.Subtract(NewIBLTFrom(a, cells)); err != nil {
+			t.Fatal(err)
+		}
+		onlyInB, onlyInA, err := tb.Decode()
+		if err == ErrDecode {
+			cells *= 2 // Estimate was too small.
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameKeys(onlyInA, onlyA) || !sameKeys(onlyInB, onlyB) {
+			t.Fatal("difference mismatched")
+		}
+		if diff := len(onlyA) + len(onlyB); cells > CellsFor(diff*4) {
+			t.Fatal("data should be O(diff)", cells, diff)
+		}
+
+		// B converges to A.
+		for _, k := range onlyInA {
+			addKey(t, b, k)
+		}
+		for _, k := range onlyInB {
+			b.Remove(k)
+		}
+		break
+	}
+	if a.Digest() != b.Digest() {
+		t.Fatal("should converge")
+	}
+}
@@ -0,0 +1,107 @@
+package reconcile
+
+import (
+	"math/bits"
+
+	"github.com/templexxx/xxh3"
+)
+
+const (
+	// strataCnt is the count of strata, stratum i has about 1/2^(i+1) of keys.
+	strataCnt = 32
+	// strataCells is the cells of each stratum's IBLT.
+	strataCells = 80
+)
+
+// Estimator is a strata estimator of the difference between two sets.
+//
+// Keys are split into strata by the trailing zeros of their hashes,
+// each stratum is a small IBLT. Estimate decodes the differences from the sparsest stratum,
+// and scales up the count when a stratum can't be decoded.
+//
+// Estimator isn't safe for concurrent use.
+type Estimator struct {
+	strata [strataCnt]*IBLT
+}
+
+// NewEstimator creates an empty Estimator.
+func NewEstimator() *Estimator {
+	e := new(Estimator)
+	for i := range e.strata {
+		e.strata[i] = NewIBLT(strataCells)
+	}
+	return e
+}
+
+func stratum(key uint64) int {
+	i := bits.TrailingZeros64(xxh3.HashU64(key, strataSeed))
+	if i >= strataCnt {
+		i = strataCnt - 1
+	}
+	return i
+}
+
+// Insert inserts key.
+func (e *Estimator) Insert(key uint64) {
+	e.strata[stratum(key)].Insert(key)
+}
+
+// Delete deletes key, key should be inserted before.
+func (e *Estimator) Delete(key uint64) {
+	e.strata[stratum(key)].Delete(key)
+}
+
+// Estimate estimates the size of symmetric difference between e & peer.
+// e & peer aren't modified.
+//
+// If even the sparsest stratum can't be decoded, the difference is too large for IBLT,
+// it returns a lower bound 1<<strataCnt (reconciling by full transfer is better).
+func (e *Estimator) Estimate(peer *Estimator) (int, error) {
+	cnt := 0
+	for i := strataCnt - 1; i >= 0; i-- {
+		d := &IBLT{cells: append([]cell(nil), e.strata[i].cells...)}
+		if err := d.Subtract(peer.strata[i]); err != nil {
+			return 0, err
+		}
+		inserted, deleted, err := d.Decode()
+		if err != nil {
+			if cnt == 0 {
+				cnt = 1 // There is at least one difference in the failed stratum.
+			}
+			return cnt << uint(i+1), nil // Keys in strata [0, i] are about 2^(i+1) times of the decoded ones.
+		}
+		cnt += len(inserted) + len(deleted)
+	}
+	return cnt, nil
+}
+
+// MarshalBinary encodes e as strata IBLTs one by one.
+func (e *Estimator) MarshalBinary() ([]byte, error) {
+	var p []byte
+	for _, t := range e.strata {
+		b, _ := t.MarshalBinary()
+		p = append(p, b...)
+	}
+	return p, nil
+}
+
+// UnmarshalBinary decodes data made by MarshalBinary into e.
+// Each stratum must have the same cells as NewEstimator's.
+func (e *Estimator) UnmarshalBinary(data []byte) error {
+	cells := NewIBLT(strataCells).Cells()
+	var err error
+	for i := range e.strata {
+		t := new(IBLT)
+		if data, err = t.unmarshal(data); err != nil {
+			return err
+		}
+		if t.Cells() != cells {
+			return ErrInvalidFormat
+		}
+		e.strata[i] = t
+	}
+	if len(data) != 0 {
+		return ErrInvalidFormat
+	}
+	return nil
+}
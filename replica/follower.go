@@ -0,0 +1,223 @@
+package replica
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/templexxx/u64"
+	"github.com/templexxx/u64/interop"
+)
+
+// Follower applies a Leader's snapshot & mutations to its Set.
+//
+// The Set should be written by Follower only, readers use it directly (e.g. Contains).
+type Follower struct {
+	set *u64.Set
+
+	mu     sync.Mutex // Serializes Sync.
+	epoch  uint64     // Epoch of the applied snapshot, it's under mu.
+	seq    uint64     // Seq of the last applied mutation.
+	synced uint32     // Has applied a snapshot.
+}
+
+// NewFollower creates a Follower which applies changes to s, s should be empty.
+func NewFollower(s *u64.Set) *Follower {
+	return &Follower{set: s}
+}
+
+// Set returns the Set of Follower.
+func (f *Follower) Set() *u64.Set {
+	return f.set
+}
+
+// Seq returns the Seq (of Leader's Set) of the last applied mutation.
+func (f *Follower) Seq() uint64 {
+	return atomic.LoadUint64(&f.seq)
+}
+
+// Synced returns true if Follower has bootstrapped from a snapshot.
+func (f *Follower) Synced() bool {
+	return atomic.LoadUint32(&f.synced) == 1
+}
+
+// Sync syncs from Leader on conn until conn fails or ctx is done,
+// it resumes from the last applied Seq if Follower has synced before.
+// conn is closed when it returns.
+func (f *Follower) Sync(ctx context.Context, conn net.Conn) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+			_ = conn.Close()
+		}
+	}()
+
+	err := f.sync(conn)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (f *Follower) sync(conn net.Conn) error {
+
+	if err := writeHello(conn, f.Synced(), f.epoch, f.Seq()); err != nil {
+		return err
+	}
+
+	r := bufio.NewReaderSize(conn, 64<<10)
+	var p [mutationSize - 1]byte
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case frameSnapshot:
+			if err = f.applySnapshot(r); err != nil {
+				return err
+			}
+		case frameMutation:
+			if !f.Synced() {
+				return ErrProtocol
+			}
+			if _, err = io.ReadFull(r, p[:]); err != nil {
+				return err
+			}
+			m, err := decodeMutation(p[:])
+			if err != nil {
+				return err
+			}
+			if err = f.apply(m); err != nil {
+				return err
+			}
+		default:
+			return ErrProtocol
+		}
+	}
+}
+
+func (f *Follower) apply(m u64.Mutation) error {
+	if m.Seq != f.Seq()+1 {
+		return ErrProtocol // Out of order.
+	}
+	if m.Op == u64.OpAdd {
+		if err := add(f.set, m.Key); err != nil {
+			return err
+		}
+	} else {
+		f.set.Remove(m.Key)
+	}
+	atomic.StoreUint64(&f.seq, m.Seq)
+	return nil
+}
+
+// applySnapshot makes Set the same as snapshot.
+// Keys in both are kept, so readers won't miss them during applying.
+func (f *Follower) applySnapshot(r *bufio.Reader) error {
+
+	var p [16]byte
+	if _, err := io.ReadFull(r, p[:]); err != nil {
+		return err
+	}
+	epoch := binary.LittleEndian.Uint64(p[:])
+	seq := binary.LittleEndian.Uint64(p[8:])
+
+	var keys []uint64
+	err := interop.ReadSortedKeys(r, func(key uint64) error {
+		keys = append(keys, key)
+		return add(f.set, key)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Range may miss keys which are moved by the expanding in background,
+	// so it retries until the count matches (all keys in snapshot are added).
+	has := func(key uint64) bool {
+		i := sort.Search(len(keys), func(i int) bool { return keys[i] >= key })
+		return i < len(keys) && keys[i] == key
+	}
+	for {
+		f.set.Range(func(key uint64) bool {
+			if !has(key) {
+				f.set.Remove(key)
+			}
+			return true
+		})
+		_, usage := f.set.GetUsage()
+		if f.set.Contains(0) {
+			usage++ // Key 0 isn't counted in usage.
+		}
+		if usage == len(keys) || !f.set.IsRunning() {
+			break
+		}
+		runtime.Gosched()
+	}
+
+	f.epoch = epoch
+	atomic.StoreUint64(&f.seq, seq)
+	atomic.StoreUint32(&f.synced, 1)
+	return nil
+}
+
+// Run syncs from Leader, and reconnects by dial after Sync returns, until ctx is done.
+// The interval of reconnecting grows from 10ms to 1s when it keeps failing.
+func (f *Follower) Run(ctx context.Context, dial func(ctx context.Context) (net.Conn, error)) error {
+
+	const (
+		minBackoff = 10 * time.Millisecond
+		maxBackoff = time.Second
+	)
+
+	backoff := minBackoff
+	for {
+		conn, err := dial(ctx)
+		if err == nil {
+			seq := f.Seq()
+			err = f.Sync(ctx, conn)
+			if f.Seq() != seq {
+				backoff = minBackoff // Made progress.
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == u64.ErrIsClosed {
+			return err // Follower's Set is closed.
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// add adds key into s, waiting for expanding if it's too fast.
+func add(s *u64.Set, key uint64) error {
+	err := s.Add(key)
+	for err == u64.ErrAddTooFast {
+		runtime.Gosched()
+		err = s.Add(key)
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+package replica
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/templexxx/u64"
+	"github.com/templexxx/u64/interop"
+)
+
+// batchSize is the max count of mutations sent in one flush.
+const batchSize = 256
+
+// Leader serves its Set to followers.
+//
+// Writers use the Set directly, Leader only reads it & subscribes its mutations.
+type Leader struct {
+	set   *u64.Set
+	ring  *ring
+	epoch uint64
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+
+	done chan struct{}
+	wg   sync.WaitGroup // drain & Serve goroutines.
+}
+
+// NewLeader creates a Leader of s,
+// backlog is the count of the recent mutations kept for followers' resuming & catching up.
+//
+// Followers which fall behind the backlog get a new snapshot (sent under the write lock of s),
+// make it big enough to cover the reconnecting & snapshot sending time.
+func NewLeader(s *u64.Set, backlog int) (*Leader, error) {
+	if backlog < 1 {
+		backlog = 1
+	}
+	epoch, err := newEpoch()
+	if err != nil {
+		return nil, err
+	}
+	sub, err := s.Subscribe(backlog)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Leader{
+		set:   s,
+		ring:  newRing(backlog),
+		epoch: epoch,
+		conns: make(map[net.Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+	l.ring.reset(sub.From())
+
+	l.wg.Add(1)
+	go l.drain(sub, backlog)
+	return l, nil
+}
+
+// drain moves mutations from subscription to ring.
+func (l *Leader) drain(sub *u64.Subscription, size int) {
+	defer l.wg.Done()
+	defer l.ring.close()
+
+	for {
+		select {
+		case <-l.done:
+			sub.Close()
+			return
+		case m, ok := <-sub.C():
+			if !ok {
+				return // Set is closed.
+			}
+			if m.Op != u64.OpGap {
+				l.ring.push(m)
+				continue
+			}
+			// Mutations are lost, followers need snapshots.
+			next, err := l.set.Subscribe(size)
+			if err != nil {
+				return
+			}
+			sub = next
+			l.ring.reset(sub.From())
+		}
+	}
+}
+
+// Serve serves a follower on conn until conn fails, follower falls behind (ErrBehind),
+// or Leader/Set is closed (u64.ErrIsClosed).
+// conn is closed when it returns.
+//
+// Serve is safe for concurrent use, one goroutine for each follower.
+// Leader.Close closes conn and waits for Serve returning.
+func (l *Leader) Serve(conn net.Conn) error {
+	defer conn.Close()
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return u64.ErrIsClosed
+	}
+	l.conns[conn] = struct{}{}
+	l.wg.Add(1)
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+		l.wg.Done()
+	}()
+
+	br := bufio.NewReader(conn)
+	resume, epoch, seq, err := readHello(br)
+	if err != nil {
+		return err
+	}
+
+	// Follower sends nothing after hello, reading returns when conn is closed.
+	stop := make(chan struct{})
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		_, _ = io.Copy(io.Discard, br)
+		close(stop)
+		l.ring.wake()
+	}()
+
+	w := bufio.NewWriterSize(conn, 64<<10)
+	from := seq + 1
+	if !resume || epoch != l.epoch || from > l.set.Seq()+1 || !l.ring.has(from) {
+		if from, err = l.sendSnapshot(w); err != nil {
+			return err
+		}
+	}
+
+	var frame [mutationSize]byte
+	batch := make([]u64.Mutation, 0, batchSize)
+	for {
+		batch, err = l.ring.read(from, batch[:0], stop)
+		if err == errTooOld {
+			return ErrBehind
+		}
+		if err != nil {
+			return err
+		}
+		for _, m := range batch {
+			encodeMutation(frame[:], m)
+			if _, err = w.Write(frame[:]); err != nil {
+				return err
+			}
+		}
+		if err = w.Flush(); err != nil {
+			return err
+		}
+		from += uint64(len(batch))
+	}
+}
+
+// sendSnapshot sends a snapshot, and returns the Seq of the next mutation.
+func (l *Leader) sendSnapshot(w *bufio.Writer) (uint64, error) {
+
+	keys, seq := l.set.Snapshot()
+	if !l.set.IsRunning() {
+		return 0, u64.ErrIsClosed
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	var p [17]byte
+	p[0] = frameSnapshot
+	binary.LittleEndian.PutUint64(p[1:], l.epoch)
+	binary.LittleEndian.PutUint64(p[9:], seq)
+	if _, err := w.Write(p[:]); err != nil {
+		return 0, err
+	}
+	if err := interop.WriteSortedKeys(w, keys); err != nil {
+		return 0, err
+	}
+	return seq + 1, w.Flush()
+}
+
+// Close stops Leader and closes all followers' connections, it won't close the Set.
+// It returns after all Serve return.
+func (l *Leader) Close() {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mu.Unlock()
+
+	close(l.done)
+	l.wg.Wait()
+}
@@ -0,0 +1,201 @@
+// Package replica replicates u64.Set from a leader to followers over net.Conn.
+//
+// Leader subscribes mutations of its Set and keeps the recent ones in a backlog (ring).
+// Follower connects with its last applied Seq & the epoch of the snapshot it applied:
+// if the epoch is leader's and the backlog still has the next one, leader sends the tail from there;
+// otherwise (the follower is new, or it's from another leader/Set whose Seq may overlap)
+// leader sends a snapshot first, then the tail after it.
+//
+// Epoch is a random ID made by each Leader, so a restarted leader with a fresh Set won't be resumed.
+//
+// Protocol (little endian):
+//
+// Follower -> Leader, hello:
+// | magic(uint32) | resume(uint8) | epoch(uint64) | seq(uint64) |
+//
+// Leader -> Follower, frames:
+// | frameSnapshot(uint8) | epoch(uint64) | seq(uint64) | keys in interop Sorted format |
+// | frameMutation(uint8) | op(uint8) | key(uint64) | seq(uint64) |
+package replica
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/templexxx/u64"
+)
+
+var (
+	// ErrProtocol is returned when the peer doesn't speak this protocol.
+	ErrProtocol = errors.New("replica protocol error")
+	// ErrBehind is returned by Leader.Serve when the follower is too slow to keep up with the backlog,
+	// the follower will get a snapshot after reconnecting.
+	ErrBehind = errors.New("follower is behind the backlog")
+)
+
+const (
+	magic = 0x52343655 // "U64R"
+
+	frameSnapshot uint8 = 1
+	frameMutation uint8 = 2
+
+	helloSize    = 21
+	mutationSize = 18
+)
+
+func writeHello(w io.Writer, resume bool, epoch, seq uint64) error {
+	var p [helloSize]byte
+	binary.LittleEndian.PutUint32(p[:], magic)
+	if resume {
+		p[4] = 1
+	}
+	binary.LittleEndian.PutUint64(p[5:], epoch)
+	binary.LittleEndian.PutUint64(p[13:], seq)
+	_, err := w.Write(p[:])
+	return err
+}
+
+func readHello(r io.Reader) (resume bool, epoch, seq uint64, err error) {
+	var p [helloSize]byte
+	if _, err = io.ReadFull(r, p[:]); err != nil {
+		return false, 0, 0, err
+	}
+	if binary.LittleEndian.Uint32(p[:]) != magic || p[4] > 1 {
+		return false, 0, 0, ErrProtocol
+	}
+	return p[4] == 1, binary.LittleEndian.Uint64(p[5:]), binary.LittleEndian.Uint64(p[13:]), nil
+}
+
+// newEpoch makes a random epoch, it's never 0.
+func newEpoch() (uint64, error) {
+	var p [8]byte
+	for {
+		if _, err := rand.Read(p[:]); err != nil {
+			return 0, err
+		}
+		if e := binary.LittleEndian.Uint64(p[:]); e != 0 {
+			return e, nil
+		}
+	}
+}
+
+// encodeMutation encodes m as a frame (with the frame type).
+func encodeMutation(p []byte, m u64.Mutation) {
+	p[0] = frameMutation
+	p[1] = uint8(m.Op)
+	binary.LittleEndian.PutUint64(p[2:], m.Key)
+	binary.LittleEndian.PutUint64(p[10:], m.Seq)
+}
+
+// decodeMutation decodes a mutation frame (without the frame type).
+func decodeMutation(p []byte) (u64.Mutation, error) {
+	m := u64.Mutation{
+		Op:  u64.Op(p[0]),
+		Key: binary.LittleEndian.Uint64(p[1:]),
+		Seq: binary.LittleEndian.Uint64(p[9:]),
+	}
+	if m.Op != u64.OpAdd && m.Op != u64.OpRemove {
+		return m, ErrProtocol
+	}
+	return m, nil
+}
+
+var errTooOld = errors.New("too old")
+
+// ring is the backlog of mutations, it keeps the last len(buf) ones.
+type ring struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []u64.Mutation
+	oldest uint64 // Seq of the oldest mutation in buf, it's last+1 if buf is empty.
+	last   uint64 // Seq of the last mutation.
+	closed bool
+}
+
+func newRing(size int) *ring {
+	r := &ring{buf: make([]u64.Mutation, size)}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// reset drops all mutations, the next one is last+1.
+func (r *ring) reset(last uint64) {
+	r.mu.Lock()
+	r.oldest, r.last = last+1, last
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+// push pushes m, m.Seq must be last+1.
+func (r *ring) push(m u64.Mutation) {
+	n := uint64(len(r.buf))
+
+	r.mu.Lock()
+	r.buf[m.Seq%n] = m
+	r.last = m.Seq
+	if r.last-r.oldest+1 > n {
+		r.oldest = r.last - n + 1
+	}
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+// has returns true if mutations from seq could be read.
+// from may be after last: it's from a snapshot which is ahead of the draining, read waits for it.
+func (r *ring) has(from uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.oldest <= from
+}
+
+// read appends mutations from seq into dst (at most cap(dst)-len(dst)),
+// it waits if there is no mutation from seq yet, until stop is closed.
+func (r *ring) read(from uint64, dst []u64.Mutation, stop <-chan struct{}) ([]u64.Mutation, error) {
+	n := uint64(len(r.buf))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for !r.closed && r.last < from && r.oldest <= from && !isDone(stop) {
+		r.cond.Wait()
+	}
+	if r.closed {
+		return dst, u64.ErrIsClosed
+	}
+	if isDone(stop) {
+		return dst, io.ErrClosedPipe
+	}
+	if from < r.oldest {
+		return dst, errTooOld
+	}
+	for seq := from; seq <= r.last && len(dst) < cap(dst); seq++ {
+		dst = append(dst, r.buf[seq%n])
+	}
+	return dst, nil
+}
+
+// wake wakes up the waiting readers for checking their stop.
+func (r *ring) wake() {
+	r.mu.Lock()
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+func isDone(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *ring) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
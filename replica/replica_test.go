@@ -0,0 +1,256 @@
+package replica
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/templexxx/u64"
+)
+
+// countingConn counts bytes written by Leader.
+type countingConn struct {
+	net.Conn
+	n *int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+func addKeys(t *testing.T, s *u64.Set, start, end uint64) {
+	t.Helper()
+	for i := start; i < end; i++ {
+		if err := add(s, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// waitSynced waits until f has applied all mutations of s, and checks they have the same digest.
+func waitSynced(t *testing.T, f *Follower, s *u64.Set) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !f.Synced() || f.Seq() != s.Seq() {
+		if time.Now().After(deadline) {
+			t.Fatal("sync timeout", f.Seq(), s.Seq())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, lu := s.GetUsage()
+	_, fu := f.Set().GetUsage()
+	if lu != fu {
+		t.Fatal("usage mismatched", lu, fu)
+	}
+	s.Range(func(key uint64) bool {
+		if !f.Set().Contains(key) {
+			t.Fatal("follower should have", key)
+		}
+		return true
+	})
+	if s.Digest() != f.Set().Digest() {
+		t.Fatal("digest mismatched")
+	}
+}
+
+// connect serves f on a new pipe, it returns the bytes written by Leader & the result of Serve.
+func connect(l *Leader, f *Follower) (written *int64, served chan error, cancel func()) {
+	c1, c2 := net.Pipe()
+	written = new(int64)
+	served = make(chan error, 1)
+	go func() {
+		served <- l.Serve(countingConn{Conn: c1, n: written})
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_ = f.Sync(ctx, c2)
+	}()
+	return written, served, cancel
+}
+
+func TestReplica(t *testing.T) {
+
+	s, _ := u64.New(8192)
+	defer s.Close()
+	addKeys(t, s, 0, 1024)
+
+	l, err := NewLeader(s, 8192)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	fs, _ := u64.New(8192)
+	defer fs.Close()
+	_ = fs.Add(1 << 40) // Not in leader, removed by snapshot.
+	f := NewFollower(fs)
+
+	_, served, cancel := connect(l, f)
+	addKeys(t, s, 1024, 4096) // Tail.
+	for i := uint64(0); i < 4096; i += 3 {
+		s.Remove(i)
+	}
+	waitSynced(t, f, s)
+	if fs.Contains(1 << 40) {
+		t.Fatal("should be removed by snapshot")
+	}
+
+	cancel()
+	if err = <-served; err == nil {
+		t.Fatal("Serve should return error after follower leaving")
+	}
+
+	// Resume from the backlog.
+	addKeys(t, s, 4096, 4196)
+	written, served, cancel := connect(l, f)
+	waitSynced(t, f, s)
+	cancel()
+	<-served // Written is counted after Write returns.
+	if n := atomic.LoadInt64(written); n != 100*mutationSize {
+		t.Fatal("should resume by tail", n)
+	}
+}
+
+func TestReplica_Behind(t *testing.T) {
+
+	s, _ := u64.New(8192)
+	defer s.Close()
+
+	l, _ := NewLeader(s, 16)
+	defer l.Close()
+
+	f := NewFollower(func() *u64.Set { fs, _ := u64.New(8192); return fs }())
+	defer f.Set().Close()
+
+	_, _, cancel := connect(l, f)
+	addKeys(t, s, 0, 10)
+	waitSynced(t, f, s)
+	cancel()
+
+	addKeys(t, s, 10, 1024) // More than backlog.
+	written, served, cancel := connect(l, f)
+	waitSynced(t, f, s)
+	cancel()
+	<-served
+	if n := atomic.LoadInt64(written); n > 1014*mutationSize/2 {
+		t.Fatal("should sync by snapshot", n)
+	}
+}
+
+func TestReplica_Run(t *testing.T) {
+
+	s, _ := u64.New(8192)
+	defer s.Close()
+
+	var leader atomic.Value // Leader is replaced in the test.
+	l, _ := NewLeader(s, 4096)
+	leader.Store(l)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _ = leader.Load().(*Leader).Serve(conn) }()
+		}
+	}()
+
+	fs, _ := u64.New(8192)
+	defer fs.Close()
+	f := NewFollower(fs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 1)
+	go func() {
+		var d net.Dialer
+		ran <- f.Run(ctx, func(ctx context.Context) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", ln.Addr().String())
+		})
+	}()
+
+	addKeys(t, s, 0, 1024)
+	waitSynced(t, f, s)
+
+	l.Close() // Follower keeps reconnecting.
+	addKeys(t, s, 1024, 2048)
+	l, _ = NewLeader(s, 4096)
+	leader.Store(l)
+	defer l.Close()
+	waitSynced(t, f, s)
+
+	cancel()
+	if err = <-ran; err != context.Canceled {
+		t.Fatal("should be canceled", err)
+	}
+}
+
+func TestReplica_NewLeader(t *testing.T) {
+
+	s, _ := u64.New(8192)
+	defer s.Close()
+	l, _ := NewLeader(s, 4096)
+
+	fs, _ := u64.New(8192)
+	defer fs.Close()
+	f := NewFollower(fs)
+
+	_, served, cancel := connect(l, f)
+	addKeys(t, s, 1, 11)
+	waitSynced(t, f, s)
+	cancel()
+	<-served
+	l.Close()
+
+	// Leader restarts with a fresh Set, its Seq covers follower's,
+	// follower must not resume from another history.
+	s2, _ := u64.New(8192)
+	defer s2.Close()
+	l2, _ := NewLeader(s2, 4096)
+	defer l2.Close()
+	addKeys(t, s2, 100, 120)
+
+	_, served, cancel = connect(l2, f)
+	waitSynced(t, f, s2)
+	cancel()
+	<-served
+	if fs.Contains(1) {
+		t.Fatal("should be synced by snapshot")
+	}
+}
+
+func TestLeader_CloseWaitsServe(t *testing.T) {
+
+	s, _ := u64.New(8192)
+	defer s.Close()
+	l, _ := NewLeader(s, 16)
+
+	f := NewFollower(func() *u64.Set { fs, _ := u64.New(8192); return fs }())
+	defer f.Set().Close()
+
+	_, served, cancel := connect(l, f)
+	defer cancel()
+	addKeys(t, s, 0, 10)
+	waitSynced(t, f, s)
+
+	l.Close()
+	l.mu.Lock()
+	n := len(l.conns) // Serve removes conn before returning.
+	l.mu.Unlock()
+	if n != 0 {
+		t.Fatal("Close should wait for Serve")
+	}
+	if err := <-served; err == nil {
+		t.Fatal("Serve should return error after Close")
+	}
+}
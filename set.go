@@ -14,8 +14,9 @@
 package u64
 
 import (
+	"context"
 	"errors"
-	"runtime"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 )
@@ -45,6 +46,28 @@ type Set struct {
 	// it's made of two uint64 slices.
 	// only the one could be inserted at a certain time.
 	cycle [2]unsafe.Pointer
+	// blooms are the bloom filters (*bloom) of tables in cycle,
+	// they are nil if WithBloom isn't used.
+	blooms [2]unsafe.Pointer
+
+	opts options
+
+	// wg tracks background goroutines (e.g. expand), Close waits for them.
+	wg sync.WaitGroup
+
+	// mem is the bytes of tables (and bloom filters) in cycle.
+	mem int64
+
+	// rec protects tables from Allocator.Free when they're being read,
+	// it's only used with Allocator.
+	rec reclaimer
+
+	// seq is the sequence number of the last mutation.
+	seq uint64
+	// subs are the subscriptions of mutations, they're protected by the write lock.
+	subs []*Subscription
+	// digest is the digest of keys, it's protected by the write lock.
+	digest digest
 }
 
 // New creates a new Set.
@@ -52,7 +75,7 @@ type Set struct {
 // Set will grow if no bucket to add until meet MaxCap.
 //
 // If cap is zero, using minCap.
-func New(cap int) (*Set, error) {
+func New(cap int, opts ...Option) (*Set, error) {
 
 	// if !isAtomic256 {
 	// 	return nil, ErrUnsupported
@@ -67,19 +90,89 @@ func New(cap int) (*Set, error) {
 		cap = MaxCap
 	}
 
-	cap = calcTableCap(cap)
-	bkt0 := make([]uint64, cap, cap) // Create one table at the beginning.
-	return &Set{
+	s := &Set{
 		status: createStatus(),
-		cycle:  [2]unsafe.Pointer{unsafe.Pointer(&bkt0)},
-	}, nil
+		opts:   makeOptions(opts),
+	}
+	if !s.reserveMem(s.memOfCap(cap)) {
+		return nil, ErrOverBudget
+	}
+	bkt0, err := s.makeTbl(calcTableCap(cap)) // Create one table at the beginning.
+	if err != nil {
+		s.freeMem(s.memOfCap(cap))
+		return nil, err
+	}
+	if b := s.opts.budget; b != nil {
+		b.register(s)
+	}
+	s.cycle[0] = unsafe.Pointer(&bkt0)
+	s.blooms[0] = s.newTblBloom(cap)
+	return s, nil
 }
 
 // Close closes Set and release the resource.
+// It returns after background goroutines (e.g. expand) stop.
 func (s *Set) Close() {
 	s.close()
-	atomic.StorePointer(&s.cycle[0], nil)
-	atomic.StorePointer(&s.cycle[1], nil)
+	s.closeSubs()
+	s.wg.Wait()
+	s.release()
+}
+
+// CloseContext closes Set like Close, but it stops waiting when ctx is done,
+// and returns ctx.Err(). In that case, Set is closed (no more writing),
+// and the resource will be released after background goroutines stop.
+func (s *Set) CloseContext(ctx context.Context) error {
+	s.close()
+	s.closeSubs()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		s.release()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release releases tables, Set must be closed and no background goroutine.
+//
+// Only Set made by New has an allocator, and its cycle holds *[]uint64.
+// Others holding Set as a field (e.g. ApproxSet, its cycle holds *approxTable) have no allocator,
+// their tables are just dropped, and they mustn't be cast.
+func (s *Set) release() {
+	for i := range s.cycle {
+		p := atomic.SwapPointer(&s.cycle[i], nil)
+		if p != nil && s.opts.alloc != nil {
+			s.retireTbl(*(*[]uint64)(p))
+		}
+	}
+	atomic.StorePointer(&s.blooms[0], nil)
+	atomic.StorePointer(&s.blooms[1], nil)
+	n := atomic.SwapInt64(&s.mem, 0) // Swap it, release may be called more than once.
+	if b := s.opts.budget; b != nil {
+		b.free(n)
+		b.unregister(s)
+	}
+}
+
+// background runs f in a new goroutine which is tracked by Close.
+// Set must be locked, and f won't run if Set is closed.
+func (s *Set) background(f func()) {
+	if !s.IsRunning() {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f()
+	}()
 }
 
 var (
@@ -89,83 +182,149 @@ var (
 	ErrIsFull      = errors.New("set is full")
 	ErrIsSealed    = errors.New("is sealed")
 	ErrExisted     = errors.New("existed")
+	// ErrIsScaling is returned by Shrink when Set is expanding/shrinking.
+	ErrIsScaling = errors.New("is scaling")
+	// ErrCannotShrink is returned by Shrink when Set is too small or too full to shrink.
+	ErrCannotShrink = errors.New("cannot shrink")
+	// ErrShrinkAborted is the ExpandEvent.Err when the new table of shrinking is full
+	// (because of concurrent Adds), keys are in the old table which is writable again.
+	ErrShrinkAborted = errors.New("shrink aborted")
 )
 
 // Add adds key into Set.
-// Return nil if succeed.
+// Return nil if succeed, ErrIsClosed after Close.
 //
 // P.S.:
 // It's better to use only one goroutine to Add at the same time,
 // it'll be more friendly for optimistic lock used by Set.
 func (s *Set) Add(key uint64) error {
+	_, err := s.TryAdd(key)
+	return err
+}
+
+// TryAdd adds key into Set like Add,
+// and reports whether key was absent before (added is true).
+// The outcome is determined under the write lock,
+// so it could be used for "first time seen" checks without Contains.
+func (s *Set) TryAdd(key uint64) (added bool, err error) {
 
 	if !s.IsRunning() {
-		return ErrIsClosed
+		return false, ErrIsClosed
 	}
 
-	err := s.tryAdd(key, false)
+	err = s.tryAdd(key, false)
 	switch err {
 
 	case nil:
 		if key != 0 {
 			s.addCnt()
 		}
+		s.publish(OpAdd, key)
 		s.unlock()
-		return nil
+		return true, nil
 	case ErrExisted:
 		s.unlock()
-		return nil
+		return false, nil
 
 	case ErrIsFull:
-		if s.isScaling() {
-			s.unlock()
-			// In practice, it's rare to have such fast adding.
-			// Which means the caller's speed if fast than 'sequential traverse'
-			return ErrAddTooFast
-		}
-
 		// Last writable table is full, try to expand to new table.
-		idx := s.getWritableIdx()
-		p := atomic.LoadPointer(&s.cycle[idx])
-		tbl := *(*[]uint64)(p)
-		oc := backToOriginCap(len(tbl))
-		if oc*2 > MaxCap {
+		if err = s.tables().grow(key); err != nil {
 			s.unlock()
-			return ErrIsFull // Already MaxCap.
+			return false, err
 		}
-
-		s.scale()
-		next := idx ^ 1
-		newTbl := make([]uint64, calcTableCap(oc*2))
-		atomic.StorePointer(&s.cycle[next], unsafe.Pointer(&newTbl))
-		s.setWritable(next)
-		_ = s.tryAdd(key, true) // First insert must be succeed.
-		go s.expand(int(idx))
 		s.addCnt()
+		s.publish(OpAdd, key)
 		s.unlock()
-		return nil
+		return true, nil
 
 	default:
+		s.unlock()
+		return false, err
+	}
+}
+
+// shrinkMaxLoad is the maximum load factor of the new table after shrinking,
+// keep it low, or moving keys may fail because of full neighbourhood (Set will be sealed).
+const shrinkMaxLoad = 0.5
+
+// Shrink shrinks Set to half capacity.
+// Keys are moved to the new table in async as expanding,
+// Set is still readable & writable during the moving.
+//
+// It returns ErrIsScaling if Set is expanding/shrinking,
+// ErrCannotShrink if capacity is minCap or the keys can't fit in half capacity well.
+//
+// If concurrent Adds fill the new table before the moving is done, shrinking is aborted:
+// the old table is writable again and keys added in the new table are moved back,
+// Set isn't sealed.
+func (s *Set) Shrink() error {
+
+restart:
+	if !s.lock() {
+		pause()
+		goto restart
+	}
+
+	if !s.IsRunning() {
+		s.unlock()
+		return ErrIsClosed
+	}
+	if s.isSealed() {
+		s.unlock()
+		return ErrIsSealed
+	}
+	if s.isScaling() {
+		s.unlock()
+		return ErrIsScaling
+	}
+
+	h := s.tables()
+	idx := s.getWritableIdx()
+	t := h.table(idx)
+	oc := backToOriginCap(t.slots())
+	nc := oc / 2
+	if nc < minCap || float64(s.getCnt()) > float64(nc)*shrinkMaxLoad {
+		s.unlock()
+		return ErrCannotShrink
+	}
+	if _, err := h.scale(t, nc); err != nil {
 		s.unlock()
 		return err
 	}
+	s.background(func() { h.expand(idx) })
+	s.unlock()
+	return nil
 }
 
 // Contains returns the key in set or not.
+// It returns false after Close.
 func (s *Set) Contains(key uint64) bool {
+	e := s.enterRead()
+	has := s.contains(key)
+	s.exitRead(e)
+	return has
+}
+
+// contains is Contains without announcing reader.
+func (s *Set) contains(key uint64) bool {
+
+	sa := atomic.LoadUint64(&s.status)
+	if !bitOne(sa, 63) {
+		return false
+	}
 
 	if key == 0 {
-		return s.hasZero()
+		return bitOne(sa, 58)
 	}
 
-	widx := s.getWritableIdx()
+	widx := getWritableIdxByStatus(sa)
 	next := widx ^ 1
 	wt := getTbl(s, int(widx))
 	nt := getTbl(s, int(next))
 
 	// 1. Search writable table first.
 	slot := getSlot(widx, wt, key)
-	if wt != nil {
+	if wt != nil && !s.bloomMiss(widx, key) {
 		slotCnt := len(wt)
 		n := neighbour
 		if slot+neighbour >= slotCnt {
@@ -186,7 +345,7 @@ func (s *Set) Contains(key uint64) bool {
 
 	// 2. If is scaling, searching next table.
 	slot = getSlot(next, nt, key)
-	if nt != nil {
+	if nt != nil && !s.bloomMiss(next, key) {
 		slotCnt := len(nt)
 		n := neighbour
 		if slot+neighbour >= slotCnt {
@@ -208,8 +367,11 @@ func (s *Set) Contains(key uint64) bool {
 }
 
 // GetUsage returns Set capacity & usage.
+// It returns zeros after Close.
 func (s *Set) GetUsage() (total, usage int) {
-	total = 0
+	if !s.IsRunning() {
+		return 0, 0
+	}
 	tbl := s.getWritableTable()
 	if tbl != nil { // In case.
 		total = backToOriginCap(len(tbl))
@@ -217,12 +379,48 @@ func (s *Set) GetUsage() (total, usage int) {
 	return total, int(s.getCnt())
 }
 
+// Stats is the statistics of Set.
+type Stats struct {
+	// Total is the capacity of Set.
+	Total int
+	// Usage is the count of keys.
+	Usage int
+	// BloomFPRate is the estimated false positive rate of the bloom filter of writable table,
+	// it's 0 if there is no bloom filter.
+	BloomFPRate float64
+	// Bytes is the memory of tables (and bloom filters),
+	// it's doubled during expanding/shrinking.
+	Bytes int64
+}
+
+// Stats returns the statistics of Set.
+// It's O(n) with the size of bloom filter.
+func (s *Set) Stats() Stats {
+	st := Stats{}
+	if !s.IsRunning() {
+		return st
+	}
+	st.Total, st.Usage = s.GetUsage()
+	st.Bytes = atomic.LoadInt64(&s.mem)
+	if b := getBloom(s, int(s.getWritableIdx())); b != nil {
+		st.BloomFPRate = b.fpRate()
+	}
+	return st
+}
+
 // Remove removes key in Set.
 func (s *Set) Remove(key uint64) {
+	_ = s.Delete(key)
+}
+
+// Delete removes key in Set like Remove,
+// and reports whether key was present before (removed is true).
+// The outcome is determined under the write lock.
+func (s *Set) Delete(key uint64) (removed bool) {
 	if !s.IsRunning() {
-		return
+		return false
 	}
-	s.tryRemove(key)
+	return s.tryRemove(key)
 }
 
 // Range calls f sequentially for each key present in the Set.
@@ -235,116 +433,28 @@ func (s *Set) Remove(key uint64) {
 //
 // Range may be O(N) with the number of elements in the set even if f returns
 // false after a constant number of calls.
+//
+// Range does nothing after Close.
 func (s *Set) Range(f func(key uint64) bool) {
 
-	widx := s.getWritableIdx()
-	wt := getTbl(s, int(widx))
-
-	next := widx ^ 1
-	nt := getTbl(s, int(next))
-
-	if wt != nil {
-		for i := len(wt) - 1; i >= 0; i-- { // DESC for avoiding visiting the same key twice caused by swap in Add process.
-
-			k := atomic.LoadUint64(&wt[i])
-			if k == 0 {
-				continue
-			}
-
-			if !f(k) {
-				break
-			}
-		}
+	if !s.IsRunning() {
+		return
 	}
 
-	if nt != nil {
-		for i := len(nt) - 1; i >= 0; i-- {
-			k := atomic.LoadUint64(&nt[i])
-			if k == 0 {
-				continue
-			}
-
-			if wt != nil {
-				slot := getSlot(widx, wt, k)
-				slotCnt := len(wt)
-				n := neighbour
-				if slot+neighbour >= slotCnt {
-					n = slotCnt - slot
-				}
-
-				has := false
-				for j := 0; j < n; j++ {
-					wk := atomic.LoadUint64(&wt[slot+j])
-					if k == wk {
-						has = true
-						break
-					}
-				}
-				if has {
-					continue
-				}
-			}
+	e := s.enterRead()
+	defer s.exitRead(e)
 
-			if !f(k) {
-				break
-			}
-		}
+	if !s.tables().scan(f) {
+		return
 	}
 
-	if s.hasZero() {
+	if s.hasZero() && s.IsRunning() {
 		if !f(0) {
 			return
 		}
 	}
 }
 
-func (s *Set) expand(ri int) {
-	rp := atomic.LoadPointer(&s.cycle[ri])
-	src := *(*[]uint64)(rp)
-
-	n, cnt := len(src), 0
-	for i := range src {
-
-		if !s.IsRunning() {
-			return
-		}
-
-		if cnt >= 10 {
-			cnt = 0
-			runtime.Gosched() // Let potential 'func Add' run.
-		}
-
-	restart:
-		if !s.lock() {
-			pause()
-			goto restart
-		}
-
-		k := atomic.LoadUint64(&src[i])
-		if k != 0 {
-			err := s.tryAdd(k, true)
-			if err == ErrIsFull {
-				s.seal()
-				s.unlock()
-				return
-			}
-
-			if err == ErrExisted {
-				s.delCnt()
-			}
-
-			cnt++
-		}
-		if i == n-1 { // Last one is finished.
-			atomic.StorePointer(&s.cycle[ri], unsafe.Pointer(nil))
-			s.unScale()
-			s.unlock()
-			return
-		}
-		s.unlock()
-	}
-}
-
 // getPosition gets key's position in tbl if has.
 func getPosition(tbl []uint64, slot int, key uint64) (has bool, pos int) {
 	if tbl != nil {
@@ -363,7 +473,12 @@ func getPosition(tbl []uint64, slot int, key uint64) (has bool, pos int) {
 	return false, 0
 }
 
-func (s *Set) tryRemove(key uint64) {
+// tryRemove removes key in both tables,
+// return true if key was found.
+//
+// Key may be in both tables when it's being moved by expand,
+// so don't stop at the first one.
+func (s *Set) tryRemove(key uint64) (removed bool) {
 
 restart:
 
@@ -372,31 +487,28 @@ restart:
 		goto restart
 	}
 
-	if key == 0 {
-		s.removeZero()
+	if !s.IsRunning() {
 		s.unlock()
-		return
+		return false
 	}
 
-	idx, tbl, slot := s.getTblSlot(key)
-	has, pos := getPosition(tbl, slot, key)
-	if has {
-		atomic.StoreUint64(&tbl[pos], 0)
-		s.delCnt()
+	if key == 0 {
+		removed = s.hasZero()
+		s.removeZero()
+		if removed {
+			s.publish(OpRemove, key)
+		}
 		s.unlock()
-		return
+		return removed
 	}
 
-	tbl, slot = s.getTblSlotByIdx(idx, key)
-	has, pos = getPosition(tbl, slot, key)
-	if has {
-		atomic.StoreUint64(&tbl[pos], 0)
+	removed = s.tables().remove(key)
+	if removed {
 		s.delCnt()
-		s.unlock()
-		return
+		s.publish(OpRemove, key)
 	}
 	s.unlock()
-	return
+	return removed
 }
 
 func (s *Set) tryAdd(key uint64, isLocked bool) (err error) {
@@ -410,87 +522,71 @@ restart:
 		}
 	}
 
+	if !s.IsRunning() {
+		return ErrIsClosed
+	}
+
 	if s.isSealed() {
 		return ErrIsSealed
 	}
 
 	if key == 0 {
+		if s.hasZero() {
+			return ErrExisted
+		}
 		s.addZero()
 		return nil
 	}
 
-	idx := s.getWritableIdx()
-	tbl := getTbl(s, int(idx))
+	return s.tables().put(key, !isLocked)
+}
 
-	// 1. Ensure key is unique. And try to find free slot within neighbourhood.
-	slotOff := neighbour // slotOff is the distance between avail slot from hashed slot.
-	slot := getSlot(idx, tbl, key)
-	if tbl != nil {
-		slotCnt := len(tbl)
-		n := neighbour
-		if slot+neighbour >= slotCnt {
-			n = slotCnt - slot
-		}
-		for i := 0; i < n; i++ {
-			k := atomic.LoadUint64(&tbl[slot+i])
-			if k == key {
-				return ErrExisted
-			}
-			if k == 0 && i < slotOff {
-				slotOff = i
-			}
-		}
-	}
+// u64Table is the table of Set, each slot is a key.
+type u64Table []uint64
 
-	// 2. Try to Add within neighbour.
-	if slotOff < neighbour {
-		atomic.StoreUint64(&tbl[slot+slotOff], key)
-		return nil
-	}
+// tables returns the hopscotch cycle of Set.
+func (s *Set) tables() hop[uint64, u64Table, *u64Table] {
+	return hop[uint64, u64Table, *u64Table]{s: s}
+}
 
-	// 3. Linear probe to find an empty slot and swap.
-	j := slot + neighbour
-	for { // Closer and closer.
-		free, status := s.swap(j, len(tbl), tbl, idx)
-		if status == swapFull {
-			return ErrIsFull
-		}
+func (t *u64Table) slots() int {
+	return len(*t)
+}
 
-		if free-slot < neighbour {
-			atomic.StoreUint64(&tbl[free], key)
-			return nil
-		}
-		j = free
-	}
+func (t *u64Table) home(idx uint8, key uint64) int {
+	return getSlot(idx, *t, key)
 }
 
-const (
-	swapOK = iota
-	swapFull
-)
+func (t *u64Table) load(i int) uint64 {
+	return atomic.LoadUint64(&(*t)[i])
+}
+
+func (t *u64Table) store(i int, key uint64) {
+	atomic.StoreUint64(&(*t)[i], key)
+}
 
-// swap swaps the free slot and the another one (closer to the hashed slot).
-// Return position & swapOK if find one.
-func (s *Set) swap(start, slotCnt int, tbl []uint64, idx uint8) (int, uint8) {
+func (t *u64Table) same(a, b uint64) bool {
+	return a == b
+}
 
-	mask := calcMask(uint32(slotCnt))
-	for i := start; i < slotCnt; i++ {
-		if atomic.LoadUint64(&tbl[i]) == 0 { // Find a free one.
-			j := i - neighbour + 1
-			if j < 0 {
-				j = 0
-			}
-			for ; j < i; j++ { // Search start at the closet position.
-				k := atomic.LoadUint64(&tbl[j])
-				slot := int(calcHash(idx, k) & mask)
-				if i-slot < neighbour {
-					atomic.StoreUint64(&tbl[j], 0)
-					atomic.StoreUint64(&tbl[i], k)
-					return j, swapOK
-				}
-			}
-			return 0, swapFull // Can't find slot for swapping. Table is full.
-		}
+func (t *u64Table) next(s *Set, cap int) (*u64Table, error) {
+	tbl, err := s.makeTbl(calcTableCap(cap))
+	if err != nil {
+		return nil, err
 	}
-	return 0, swapFull
+	nt := u64Table(tbl)
+	return &nt, nil
+}
+
+func (t *u64Table) mem(s *Set, cap int) int64 {
+	return s.memOfCap(cap)
+}
+
+func (t *u64Table) retire(s *Set) {
+	s.retireTbl(*t)
+}
+
+// inserting adds key into the bloom filter, it must have key before key is visible in table.
+func (t *u64Table) inserting(s *Set, idx uint8, key uint64) {
+	s.bloomAdd(idx, key)
 }
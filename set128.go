@@ -0,0 +1,278 @@
+package u64
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/templexxx/xxh3"
+)
+
+// Set128 is 128-bit key set (e.g. UUID, content hash),
+// it's in the same neighbourhood layout & two tables cycle as Set.
+//
+// Each slot has 16Bytes (aligned to 16Bytes), and it's loaded & stored by 16Bytes atomic instructions,
+// so Contains is still wait-free and never sees a torn key.
+//
+// Key {0, 0} is flagged by status as key 0 in Set.
+type Set128 struct {
+	// set holds status & cycle, tables in cycle are *tbl128.
+	set Set
+}
+
+// NewSet128 creates a new Set128.
+// cap is the set capacity at the beginning, see New for details.
+//
+// It returns ErrUnsupported if CPU has no CMPXCHG16B.
+func NewSet128(cap int) (*Set128, error) {
+
+	if !isAtomic128 {
+		return nil, ErrUnsupported
+	}
+
+	cap = int(nextPower2(uint64(cap)))
+
+	if cap < minCap {
+		cap = minCap
+	}
+	if cap > MaxCap {
+		cap = MaxCap
+	}
+
+	s := &Set128{}
+	s.set.status = createStatus()
+	tbl := tbl128(makeTbl128(calcTableCap(cap)))
+	s.set.reserveMem(tbl.mem(&s.set, cap))
+	s.set.cycle[0] = unsafe.Pointer(&tbl)
+	return s, nil
+}
+
+// makeTbl128 makes a table which has n slots and is aligned to 16Bytes.
+func makeTbl128(n int) []uint64 {
+	p := make([]uint64, n*2+1)
+	off := int(uintptr(unsafe.Pointer(&p[0])) & 15 / 8)
+	return p[off : off+n*2]
+}
+
+func calcHash128(idx uint8, key [2]uint64) uint32 {
+	seed := uint64(idx)
+	return uint32(xxh3.HashU64(key[0]^xxh3.HashU64(key[1], seed), seed))
+}
+
+func isZero128(key [2]uint64) bool {
+	return key[0] == 0 && key[1] == 0
+}
+
+// tbl128 is the table of Set128, slot i is tbl[2i] (low 64 bits) & tbl[2i+1] (high 64 bits).
+type tbl128 []uint64
+
+// tables returns the hopscotch cycle of Set128.
+func (s *Set128) tables() hop[[2]uint64, tbl128, *tbl128] {
+	return hop[[2]uint64, tbl128, *tbl128]{s: &s.set}
+}
+
+func (t *tbl128) slots() int {
+	return len(*t) / 2
+}
+
+func (t *tbl128) home(idx uint8, key [2]uint64) int {
+	return int(calcHash128(idx, key) & calcMask(uint32(len(*t)/2)))
+}
+
+func (t *tbl128) load(i int) [2]uint64 {
+	lo, hi := load128(&(*t)[i*2])
+	return [2]uint64{lo, hi}
+}
+
+func (t *tbl128) store(i int, key [2]uint64) {
+	store128(&(*t)[i*2], key[0], key[1])
+}
+
+func (t *tbl128) same(a, b [2]uint64) bool {
+	return a == b
+}
+
+func (t *tbl128) next(_ *Set, cap int) (*tbl128, error) {
+	nt := tbl128(makeTbl128(calcTableCap(cap)))
+	return &nt, nil
+}
+
+func (t *tbl128) mem(_ *Set, cap int) int64 {
+	return int64(calcTableCap(cap)) * 16
+}
+
+func (t *tbl128) retire(_ *Set) {}
+
+func (t *tbl128) inserting(_ *Set, _ uint8, _ [2]uint64) {}
+
+// Contains returns the key in set or not.
+func (s *Set128) Contains(key [2]uint64) bool {
+
+	sa := atomic.LoadUint64(&s.set.status)
+	if !bitOne(sa, 63) {
+		return false
+	}
+
+	if isZero128(key) {
+		return bitOne(sa, 58)
+	}
+
+	h := s.tables()
+	widx := getWritableIdxByStatus(sa)
+	for _, idx := range [2]uint8{widx, widx ^ 1} {
+		if has, _ := h.find(h.table(idx), idx, key); has {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds key into Set128.
+// Return nil if succeed.
+func (s *Set128) Add(key [2]uint64) error {
+	_, err := s.TryAdd(key)
+	return err
+}
+
+// TryAdd adds key into Set128 like Add,
+// and reports whether key was absent before (added is true).
+func (s *Set128) TryAdd(key [2]uint64) (added bool, err error) {
+
+	if !s.IsRunning() {
+		return false, ErrIsClosed
+	}
+
+	err = s.tryAdd(key)
+	switch err {
+
+	case nil:
+		if !isZero128(key) {
+			s.set.addCnt()
+		}
+		s.set.unlock()
+		return true, nil
+	case ErrExisted:
+		s.set.unlock()
+		return false, nil
+
+	case ErrIsFull:
+		// Last writable table is full, try to expand to new table.
+		if err = s.tables().grow(key); err != nil {
+			s.set.unlock()
+			return false, err
+		}
+		s.set.addCnt()
+		s.set.unlock()
+		return true, nil
+
+	default:
+		s.set.unlock()
+		return false, err
+	}
+}
+
+// tryAdd locks Set128 and adds key into the writable table, caller unlocks it.
+func (s *Set128) tryAdd(key [2]uint64) (err error) {
+
+restart:
+	if !s.set.lock() {
+		pause()
+		goto restart
+	}
+
+	if !s.set.IsRunning() {
+		return ErrIsClosed
+	}
+
+	if s.set.isSealed() {
+		return ErrIsSealed
+	}
+
+	if isZero128(key) {
+		if s.set.hasZero() {
+			return ErrExisted
+		}
+		s.set.addZero()
+		return nil
+	}
+
+	return s.tables().put(key, true)
+}
+
+// Remove removes key in Set128.
+func (s *Set128) Remove(key [2]uint64) {
+	_ = s.Delete(key)
+}
+
+// Delete removes key in Set128 like Remove,
+// and reports whether key was present before (removed is true).
+func (s *Set128) Delete(key [2]uint64) (removed bool) {
+
+	if !s.IsRunning() {
+		return false
+	}
+
+restart:
+	if !s.set.lock() {
+		pause()
+		goto restart
+	}
+
+	if !s.set.IsRunning() {
+		s.set.unlock()
+		return false
+	}
+
+	if isZero128(key) {
+		removed = s.set.hasZero()
+		s.set.removeZero()
+		s.set.unlock()
+		return removed
+	}
+
+	removed = s.tables().remove(key)
+	if removed {
+		s.set.delCnt()
+	}
+	s.set.unlock()
+	return removed
+}
+
+// Range calls f sequentially for each key present in the Set128.
+// If f returns false, range stops the iteration.
+//
+// Range has the same consistency as Set.Range.
+func (s *Set128) Range(f func(key [2]uint64) bool) {
+
+	if !s.IsRunning() {
+		return
+	}
+
+	if !s.tables().scan(f) {
+		return
+	}
+
+	if s.set.hasZero() && s.IsRunning() {
+		f([2]uint64{})
+	}
+}
+
+// GetUsage returns Set128 capacity & usage.
+func (s *Set128) GetUsage() (total, usage int) {
+	if !s.IsRunning() {
+		return 0, 0
+	}
+	if t := s.tables().table(s.set.getWritableIdx()); t != nil {
+		total = backToOriginCap(t.slots())
+	}
+	return total, int(s.set.getCnt())
+}
+
+// IsRunning returns Set128 is running or not.
+func (s *Set128) IsRunning() bool {
+	return s.set.IsRunning()
+}
+
+// Close closes Set128 and release the resource.
+func (s *Set128) Close() {
+	s.set.Close()
+}
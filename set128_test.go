@@ -0,0 +1,171 @@
+package u64
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestAtomic128(t *testing.T) {
+
+	if !isAtomic128 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	tbl := makeTbl128(4)
+	if uintptr(unsafe.Pointer(&tbl[0]))&15 != 0 {
+		t.Fatal("table should be aligned to 16Bytes")
+	}
+
+	store128(&tbl[2], 1, 2)
+	for _, load := range []func(*uint64) (uint64, uint64){load128CAS, load128} {
+		lo, hi := load(&tbl[2])
+		if lo != 1 || hi != 2 {
+			t.Fatal("mismatched", lo, hi)
+		}
+		lo, hi = load(&tbl[4])
+		if lo != 0 || hi != 0 {
+			t.Fatal("should be zero", lo, hi)
+		}
+	}
+}
+
+func TestAtomic128Torn(t *testing.T) {
+
+	if !isAtomic128 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	tbl := makeTbl128(1)
+	store128(&tbl[0], 0, ^uint64(0))
+	var stop uint32
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := uint64(0); atomic.LoadUint32(&stop) == 0; i++ {
+			store128(&tbl[0], i, ^i)
+		}
+	}()
+
+	for i := 0; i < 1<<20; i++ {
+		lo, hi := load128(&tbl[0])
+		if hi != ^lo {
+			atomic.StoreUint32(&stop, 1)
+			t.Fatal("torn key", lo, hi)
+		}
+	}
+	atomic.StoreUint32(&stop, 1)
+	wg.Wait()
+}
+
+func TestSet128(t *testing.T) {
+
+	if !isAtomic128 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	cnt := 3 << 11
+	s, err := NewSet128(4096) // Not enough capacity, must trigger expand.
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	key := func(i int) [2]uint64 {
+		return [2]uint64{uint64(i), uint64(i) * 3}
+	}
+
+	for i := 0; i < cnt; i++ {
+		added, err := s.TryAdd(key(i))
+		for err == ErrAddTooFast { // Waiting for expanding.
+			time.Sleep(time.Millisecond)
+			added, err = s.TryAdd(key(i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added {
+			t.Fatal("should be added", i)
+		}
+		if !s.Contains(key(i)) {
+			t.Fatal("should have key", i)
+		}
+	}
+	if added, _ := s.TryAdd(key(1)); added {
+		t.Fatal("should be existed")
+	}
+	for s.set.isScaling() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < cnt; i++ {
+		if !s.Contains(key(i)) {
+			t.Fatal("should have key", i)
+		}
+		if s.Contains([2]uint64{uint64(i), uint64(i)*3 + 1}) {
+			t.Fatal("should not have key", i)
+		}
+	}
+
+	total, usage := s.GetUsage()
+	if total != 8192 || usage != cnt-1 { // key(0) is {0, 0}, it's not counted in usage.
+		t.Fatal("usage mismatched", total, usage)
+	}
+
+	n := 0
+	s.Range(func(k [2]uint64) bool {
+		if k[1] != k[0]*3 {
+			t.Fatal("range mismatched", k)
+		}
+		n++
+		return true
+	})
+	if n != cnt {
+		t.Fatal("range count mismatched", n, cnt)
+	}
+
+	for i := 0; i < cnt; i++ {
+		if !s.Delete(key(i)) {
+			t.Fatal("should be removed", i)
+		}
+		if s.Contains(key(i)) {
+			t.Fatal("should not have key", i)
+		}
+	}
+	_, usage = s.GetUsage()
+	if usage != 0 {
+		t.Fatal("usage mismatched", usage)
+	}
+}
+
+func TestSet128_Close(t *testing.T) {
+
+	if !isAtomic128 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	s, _ := NewSet128(0)
+	_ = s.Add([2]uint64{})
+	_ = s.Add([2]uint64{1, 2})
+	s.Close()
+
+	if err := s.Add([2]uint64{1, 3}); err != ErrIsClosed {
+		t.Fatal("Add should return ErrIsClosed", err)
+	}
+	if s.Contains([2]uint64{}) || s.Contains([2]uint64{1, 2}) {
+		t.Fatal("Contains should be false")
+	}
+	if s.Delete([2]uint64{1, 2}) {
+		t.Fatal("Delete should be false")
+	}
+	if total, usage := s.GetUsage(); total != 0 || usage != 0 {
+		t.Fatal("GetUsage should be zeros", total, usage)
+	}
+	s.Range(func(_ [2]uint64) bool {
+		t.Fatal("Range should do nothing")
+		return false
+	})
+}
@@ -1,9 +1,12 @@
 package u64
 
 import (
+	"context"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSet_AddZero(t *testing.T) {
@@ -90,6 +93,73 @@ func TestSet_Remove(t *testing.T) {
 	}
 }
 
+func TestSet_TryAdd(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	cnt := 1 << 13
+	s, _ := New(cnt / 2) // Not enough capacity, must trigger expand.
+
+	for i := 0; i <= cnt; i++ {
+		added, err := s.TryAdd(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added {
+			t.Fatal("should be added")
+		}
+		// Key may be in the older table, it's still existed.
+		added, err = s.TryAdd(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added {
+			t.Fatal("should not be added twice")
+		}
+	}
+
+	_, usage := s.GetUsage()
+	if usage != cnt {
+		t.Fatal("usage mismatched", usage)
+	}
+}
+
+func TestSet_Delete(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	cnt := 1 << 13
+	s, _ := New(cnt / 2) // Not enough capacity, must trigger expand.
+
+	for i := 0; i <= cnt; i++ {
+		err := s.Add(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i <= cnt; i++ {
+		if !s.Delete(uint64(i)) {
+			t.Fatal("should be removed")
+		}
+		if s.Delete(uint64(i)) {
+			t.Fatal("should not be removed twice")
+		}
+		if s.Contains(uint64(i)) {
+			t.Fatal("should not have key")
+		}
+	}
+
+	_, usage := s.GetUsage()
+	if usage != 0 {
+		t.Fatal("usage mismatched", usage)
+	}
+}
+
 // Add & Remove concurrently, checking dead lock or not.
 func TestSet_UpdateConcurrent(t *testing.T) {
 
@@ -296,3 +366,240 @@ func TestConcurrentRange(t *testing.T) {
 		}
 	}
 }
+
+func TestSet_CloseWaitsExpand(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	before := runtime.NumGoroutine()
+
+	s, _ := New(1024)
+	for i := 1; i <= 4096; i++ { // Must trigger expand.
+		if err := s.Add(uint64(i)); err != nil && err != ErrAddTooFast {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatal("background goroutine leaked", before, after)
+	}
+	if getTbl(s, 0) != nil || getTbl(s, 1) != nil {
+		t.Fatal("tables should be released")
+	}
+}
+
+func TestSet_CloseContext(t *testing.T) {
+
+	s, _ := New(0)
+	s.wg.Add(1) // Fake a background goroutine.
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Fatal("should be deadline exceeded", err)
+	}
+	if s.IsRunning() {
+		t.Fatal("should be closed")
+	}
+	if getTbl(s, 0) == nil {
+		t.Fatal("table should not be released before background goroutine stops")
+	}
+
+	s.wg.Done()
+	for getTbl(s, 0) != nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := s.CloseContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// raceClose runs ops concurrently (with increasing i) and calls closeFn in the middle.
+// ops must not panic after closeFn.
+func raceClose(ops []func(i int), closeFn func()) {
+
+	var stop uint32
+	var wg sync.WaitGroup
+	for _, op := range ops {
+		wg.Add(1)
+		go func(op func(i int)) {
+			defer wg.Done()
+			for i := 0; atomic.LoadUint32(&stop) == 0; i++ {
+				op(i)
+			}
+		}(op)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	closeFn()
+	time.Sleep(5 * time.Millisecond)
+	atomic.StoreUint32(&stop, 1)
+	wg.Wait()
+}
+
+func TestSet_CloseRace(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	for j := 0; j < 2; j++ {
+		s, _ := New(64) // Small one, expand is running when closing.
+		raceClose([]func(i int){
+			func(i int) { _ = s.Add(uint64(i)) },
+			func(i int) { _, _ = s.TryAdd(uint64(i) << 1) },
+			func(i int) { s.Contains(uint64(i)) },
+			func(i int) { s.Remove(uint64(i)) },
+			func(i int) { s.Delete(uint64(i) << 1) },
+			func(i int) { s.GetUsage(); s.Stats() },
+			func(i int) { s.Range(func(key uint64) bool { return key < uint64(i) }) },
+		}, s.Close)
+
+		checkClosed(t, s)
+	}
+}
+
+func checkClosed(t *testing.T, s *Set) {
+	t.Helper()
+
+	if err := s.Add(1); err != ErrIsClosed {
+		t.Fatal("Add should return ErrIsClosed", err)
+	}
+	if _, err := s.TryAdd(0); err != ErrIsClosed {
+		t.Fatal("TryAdd should return ErrIsClosed", err)
+	}
+	if s.Contains(0) || s.Contains(1) {
+		t.Fatal("Contains should be false")
+	}
+	if s.Delete(0) || s.Delete(1) {
+		t.Fatal("Delete should be false")
+	}
+	if total, usage := s.GetUsage(); total != 0 || usage != 0 {
+		t.Fatal("GetUsage should be zeros", total, usage)
+	}
+	if st := s.Stats(); st != (Stats{}) {
+		t.Fatal("Stats should be zeros", st)
+	}
+	s.Range(func(key uint64) bool {
+		t.Fatal("Range should do nothing")
+		return false
+	})
+	s.Close() // Close again is ok.
+}
+
+type testObserver struct {
+	mu    sync.Mutex
+	start []ExpandEvent
+	done  []ExpandEvent
+	seal  []SealEvent
+}
+
+func (o *testObserver) OnExpandStart(e ExpandEvent) {
+	o.mu.Lock()
+	o.start = append(o.start, e)
+	o.mu.Unlock()
+}
+
+func (o *testObserver) OnExpandDone(e ExpandEvent) {
+	o.mu.Lock()
+	o.done = append(o.done, e)
+	o.mu.Unlock()
+}
+
+func (o *testObserver) OnSealed(e SealEvent) {
+	o.mu.Lock()
+	o.seal = append(o.seal, e)
+	o.mu.Unlock()
+}
+
+func TestSet_Observer(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	o := new(testObserver)
+	s, _ := New(1024, WithObserver(o))
+	defer s.Close()
+
+	cnt := 1200 // Must trigger expand once.
+	for i := 1; i <= cnt; i++ {
+		err := s.Add(uint64(i))
+		for err == ErrAddTooFast {
+			time.Sleep(time.Millisecond)
+			err = s.Add(uint64(i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 1000; i++ { // OnExpandDone is called after unScale.
+		o.mu.Lock()
+		n := len(o.done)
+		o.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.start) != 1 || len(o.done) != 1 || len(o.seal) != 0 {
+		t.Fatal("events count mismatched", len(o.start), len(o.done), len(o.seal))
+	}
+	if o.start[0].OldCap != 1024 || o.start[0].NewCap != 2048 {
+		t.Fatal("start event mismatched", o.start[0])
+	}
+	done := o.done[0]
+	if done.OldCap != 1024 || done.NewCap != 2048 || done.Err != nil ||
+		done.Migrated == 0 || done.Migrated >= cnt || done.Duration <= 0 {
+		t.Fatal("done event mismatched", done)
+	}
+}
+
+func TestSet_ObserverShrink(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	o := new(testObserver)
+	s, _ := New(2048, WithObserver(o))
+	defer s.Close()
+
+	cnt := 100
+	for i := 1; i <= cnt; i++ {
+		if err := s.Add(uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Shrink(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		o.mu.Lock()
+		n := len(o.done)
+		o.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.start) != 1 || len(o.done) != 1 || len(o.seal) != 0 {
+		t.Fatal("events count mismatched", len(o.start), len(o.done), len(o.seal))
+	}
+	done := o.done[0]
+	if done.OldCap != 2048 || done.NewCap != 1024 || done.Err != nil || done.Migrated != cnt {
+		t.Fatal("done event mismatched", done)
+	}
+}
@@ -0,0 +1,434 @@
+package u64
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// SharedSet is a Set which status & tables live in a shared file mapping (e.g. a file in /dev/shm),
+// one process writes by SharedSet, other processes open the file by OpenSharedReader
+// and run wait-free Contains.
+//
+// File layout:
+// | header (one page) | table regions ... |
+//
+// header (little endian):
+// | magic(4) | version(4) | status(8) | table_0(8) | table_1(8) | end(8) |
+//
+// status: a copy of Set's status (locked bit is always clear), it's published by the writer
+// after each operation & table swap, not the live word of Set.
+// table_i: the descriptor of cycle[i]: offset_in_pages << 26 | slots, 0 means no table.
+// end: the end of the last table region.
+//
+// New tables are allocated at the end of file, and the retired regions won't be reused
+// (readers may be still reading them during the table swap), so the file size is less than twice of the tables'.
+//
+// SharedSet is only supported on Linux.
+type SharedSet struct {
+	set   *Set
+	f     *os.File
+	hdr   []byte
+	alloc *fileAllocator
+
+	mu sync.Mutex // Only one publish at the same time.
+}
+
+const (
+	sharedMagic   = 0x53343655 // "U64S"
+	sharedVersion = 1
+
+	sharedStatusOff = 8
+	sharedTblOff    = 16
+	sharedEndOff    = 32
+
+	// sharedSlotsBits is the bits of slots in table descriptor.
+	sharedSlotsBits = 26
+)
+
+var ErrInvalidShared = errors.New("invalid shared set file")
+
+// NewSharedSet creates a SharedSet in file path (it'll be truncated if existed).
+// cap is the set capacity at the beginning, see New for details.
+func NewSharedSet(path string, cap int) (*SharedSet, error) {
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := os.Getpagesize()
+	if err = f.Truncate(int64(pageSize)); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	hdr, err := mmapFile(f, 0, pageSize, true)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(hdr[0:4], sharedMagic)
+	binary.LittleEndian.PutUint32(hdr[4:8], sharedVersion)
+
+	s := &SharedSet{f: f, hdr: hdr}
+	s.alloc = &fileAllocator{
+		f:        f,
+		pageSize: int64(pageSize),
+		end:      hdrWord(hdr, sharedEndOff),
+		maps:     make(map[*uint64]fileMapping),
+	}
+	atomic.StoreUint64(s.alloc.end, uint64(pageSize))
+
+	s.set, err = New(cap, WithAllocator(s.alloc), WithObserver(sharedObserver{s}))
+	if err != nil {
+		_ = munmapFile(hdr)
+		_ = f.Close()
+		return nil, err
+	}
+	s.publish()
+	return s, nil
+}
+
+func hdrWord(hdr []byte, off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&hdr[off]))
+}
+
+// publish publishes tables & status to header,
+// it must be called after changing Set.
+func (s *SharedSet) publish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Tables first, readers load status first.
+	for i := 0; i < 2; i++ {
+		atomic.StoreUint64(hdrWord(s.hdr, sharedTblOff+i*8), s.alloc.desc(getTbl(s.set, i)))
+	}
+	atomic.StoreUint64(hdrWord(s.hdr, sharedStatusOff), clrBit(atomic.LoadUint64(&s.set.status), 62))
+}
+
+// sharedObserver publishes table swaps which are made by the background expanding.
+type sharedObserver struct {
+	s *SharedSet
+}
+
+func (o sharedObserver) OnExpandStart(_ ExpandEvent) { o.s.publish() }
+func (o sharedObserver) OnExpandDone(_ ExpandEvent)  { o.s.publish() }
+func (o sharedObserver) OnSealed(_ SealEvent)        { o.s.publish() }
+
+// Add adds key into SharedSet.
+// Return nil if succeed.
+func (s *SharedSet) Add(key uint64) error {
+	_, err := s.TryAdd(key)
+	return err
+}
+
+// TryAdd adds key into SharedSet like Set.TryAdd.
+func (s *SharedSet) TryAdd(key uint64) (added bool, err error) {
+	added, err = s.set.TryAdd(key)
+	s.publish()
+	return
+}
+
+// Remove removes key in SharedSet.
+func (s *SharedSet) Remove(key uint64) {
+	_ = s.Delete(key)
+}
+
+// Delete removes key in SharedSet like Set.Delete.
+func (s *SharedSet) Delete(key uint64) (removed bool) {
+	removed = s.set.Delete(key)
+	s.publish()
+	return
+}
+
+// Contains returns the key in set or not.
+func (s *SharedSet) Contains(key uint64) bool {
+	return s.set.Contains(key)
+}
+
+// GetUsage returns SharedSet capacity & usage.
+func (s *SharedSet) GetUsage() (total, usage int) {
+	return s.set.GetUsage()
+}
+
+// IsRunning returns SharedSet is running or not.
+func (s *SharedSet) IsRunning() bool {
+	return s.set.IsRunning()
+}
+
+// Close closes SharedSet, readers will see it's closed.
+// The file is kept.
+func (s *SharedSet) Close() error {
+	s.set.Close()
+	s.publish()
+
+	err := munmapFile(s.hdr)
+	if err2 := s.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// fileAllocator allocates tables at the end of file.
+type fileAllocator struct {
+	mu       sync.Mutex
+	f        *os.File
+	pageSize int64
+	end      *uint64 // In header.
+	maps     map[*uint64]fileMapping
+}
+
+type fileMapping struct {
+	data []byte
+	off  int64
+}
+
+func (a *fileAllocator) Alloc(n int) ([]uint64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	off := int64(atomic.LoadUint64(a.end))
+	size := (int64(n)*8 + a.pageSize - 1) / a.pageSize * a.pageSize
+	if err := a.f.Truncate(off + size); err != nil { // New region is zeroed.
+		return nil, err
+	}
+	data, err := mmapFile(a.f, off, n*8, true)
+	if err != nil {
+		return nil, err
+	}
+	tbl := unsafe.Slice((*uint64)(unsafe.Pointer(&data[0])), n)
+	a.maps[&tbl[0]] = fileMapping{data: data, off: off}
+	atomic.StoreUint64(a.end, uint64(off+size))
+	return tbl, nil
+}
+
+// Free unmaps tbl in this process, the region in file is kept for readers.
+func (a *fileAllocator) Free(tbl []uint64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	m, ok := a.maps[&tbl[0]]
+	if !ok {
+		return nil
+	}
+	delete(a.maps, &tbl[0])
+	return munmapFile(m.data)
+}
+
+// desc returns the descriptor of tbl, 0 if tbl is nil or freed.
+func (a *fileAllocator) desc(tbl []uint64) uint64 {
+	if len(tbl) == 0 {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	m, ok := a.maps[&tbl[0]]
+	if !ok {
+		return 0
+	}
+	return uint64(m.off/a.pageSize)<<sharedSlotsBits | uint64(len(tbl))
+}
+
+// SharedReader reads a SharedSet in another process (or the same one).
+//
+// Contains is wait-free as Set.Contains,
+// except the first one after a table swap which maps the new table.
+// Reader sees the status published by the writer (see SharedSet), so a key is visible after Add returns.
+//
+// If mapping a table fails, the reader is broken: Contains & Refresh return the error from then on,
+// instead of reporting keys absent.
+type SharedReader struct {
+	f        *os.File
+	hdr      []byte
+	pageSize int64
+
+	broken atomic.Value // The error of mapping.
+
+	mu sync.Mutex // Only one mapping at the same time.
+	// regions is *[]sharedRegion, it's replaced (copy on write) when mapping new table.
+	// Regions are kept until Close, there are only a few of them (one for each expanding).
+	regions unsafe.Pointer
+}
+
+type sharedRegion struct {
+	desc uint64
+	data []byte
+	tbl  []uint64
+}
+
+// OpenSharedReader opens the file of a SharedSet read-only.
+func OpenSharedReader(path string) (*SharedReader, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := os.Getpagesize()
+	hdr, err := mmapFile(f, 0, pageSize, false)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(hdr[0:4]) != sharedMagic ||
+		binary.LittleEndian.Uint32(hdr[4:8]) != sharedVersion {
+		_ = munmapFile(hdr)
+		_ = f.Close()
+		return nil, ErrInvalidShared
+	}
+
+	regions := make([]sharedRegion, 0)
+	return &SharedReader{
+		f:        f,
+		hdr:      hdr,
+		pageSize: int64(pageSize),
+		regions:  unsafe.Pointer(&regions),
+	}, nil
+}
+
+// table returns the table of descriptor, nil if desc is 0.
+func (r *SharedReader) table(desc uint64) ([]uint64, error) {
+	if desc == 0 {
+		return nil, nil
+	}
+	for _, rg := range *(*[]sharedRegion)(atomic.LoadPointer(&r.regions)) {
+		if rg.desc == desc {
+			return rg.tbl, nil
+		}
+	}
+	return r.mapTable(desc)
+}
+
+func (r *SharedReader) mapTable(desc uint64) ([]uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	regions := *(*[]sharedRegion)(atomic.LoadPointer(&r.regions))
+	for _, rg := range regions {
+		if rg.desc == desc {
+			return rg.tbl, nil
+		}
+	}
+
+	n := int(desc & (1<<sharedSlotsBits - 1))
+	off := int64(desc>>sharedSlotsBits) * r.pageSize
+	data, err := mmapFile(r.f, off, n*8, false)
+	if err != nil {
+		r.broken.Store(err)
+		return nil, err
+	}
+	rg := sharedRegion{
+		desc: desc,
+		data: data,
+		tbl:  unsafe.Slice((*uint64)(unsafe.Pointer(&data[0])), n),
+	}
+	next := make([]sharedRegion, len(regions)+1)
+	copy(next, regions)
+	next[len(regions)] = rg
+	atomic.StorePointer(&r.regions, unsafe.Pointer(&next))
+	return rg.tbl, nil
+}
+
+// err returns the error which broke the reader.
+func (r *SharedReader) err() error {
+	if err, ok := r.broken.Load().(error); ok {
+		return err
+	}
+	return nil
+}
+
+func (r *SharedReader) loadStatus() uint64 {
+	return atomic.LoadUint64(hdrWord(r.hdr, sharedStatusOff))
+}
+
+func (r *SharedReader) loadDesc(idx uint8) uint64 {
+	return atomic.LoadUint64(hdrWord(r.hdr, sharedTblOff+int(idx)*8))
+}
+
+// Contains returns the key in set or not.
+// It returns false if the SharedSet is closed,
+// and the error if the reader is broken (see SharedReader).
+func (r *SharedReader) Contains(key uint64) (bool, error) {
+
+	if err := r.err(); err != nil {
+		return false, err
+	}
+
+	sa := r.loadStatus()
+	if !bitOne(sa, 63) {
+		return false, nil
+	}
+
+	if key == 0 {
+		return bitOne(sa, 58), nil
+	}
+
+	widx := getWritableIdxByStatus(sa)
+	for _, idx := range [2]uint8{widx, widx ^ 1} {
+		tbl, err := r.table(r.loadDesc(idx))
+		if err != nil {
+			return false, err
+		}
+		if tbl == nil {
+			continue
+		}
+		if has, _ := getPosition(tbl, getSlot(idx, tbl, key), key); has {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Refresh maps the tables published now, so the next Contains won't map them.
+// It returns the error if the reader is broken.
+func (r *SharedReader) Refresh() error {
+	for idx := uint8(0); idx < 2; idx++ {
+		if _, err := r.table(r.loadDesc(idx)); err != nil {
+			return err
+		}
+	}
+	return r.err()
+}
+
+// GetUsage returns SharedSet capacity & usage.
+func (r *SharedReader) GetUsage() (total, usage int) {
+	sa := r.loadStatus()
+	if !bitOne(sa, 63) {
+		return 0, 0
+	}
+	if desc := r.loadDesc(getWritableIdxByStatus(sa)); desc != 0 { // Slots are in descriptor, no mapping.
+		total = backToOriginCap(int(desc & (1<<sharedSlotsBits - 1)))
+	}
+	return total, int(sa & cntMask)
+}
+
+// IsRunning returns the SharedSet is running or not.
+func (r *SharedReader) IsRunning() bool {
+	return bitOne(r.loadStatus(), 63)
+}
+
+// Close closes SharedReader, Contains mustn't be called after it.
+func (r *SharedReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	for _, rg := range *(*[]sharedRegion)(atomic.LoadPointer(&r.regions)) {
+		if err2 := munmapFile(rg.data); err == nil {
+			err = err2
+		}
+	}
+	if err2 := munmapFile(r.hdr); err == nil {
+		err = err2
+	}
+	if err2 := r.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,19 @@
+package u64
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps n bytes of f at off (shared), off must be aligned to page size.
+func mmapFile(f *os.File, off int64, n int, writable bool) ([]byte, error) {
+	prot := syscall.PROT_READ
+	if writable {
+		prot |= syscall.PROT_WRITE
+	}
+	return syscall.Mmap(int(f.Fd()), off, n, prot, syscall.MAP_SHARED)
+}
+
+func munmapFile(b []byte) error {
+	return syscall.Munmap(b)
+}
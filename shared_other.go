@@ -0,0 +1,13 @@
+//go:build !linux
+
+package u64
+
+import "os"
+
+func mmapFile(_ *os.File, _ int64, _ int, _ bool) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+func munmapFile(_ []byte) error {
+	return ErrUnsupported
+}
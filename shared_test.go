@@ -0,0 +1,205 @@
+package u64
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestSharedSet(t *testing.T, cap int) (*SharedSet, string) {
+	path := filepath.Join(t.TempDir(), "set")
+	s, err := NewSharedSet(path, cap)
+	if err == ErrUnsupported {
+		t.Skip(err.Error())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func readerHas(t *testing.T, r *SharedReader, key uint64) bool {
+	t.Helper()
+	has, err := r.Contains(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return has
+}
+
+func TestSharedSet(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	w, path := newTestSharedSet(t, 4096) // Not enough capacity, must trigger expand.
+	r, err := OpenSharedReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Keys which have been added must be seen by reader, even during table swaps.
+	cnt := 3 << 11
+	var added uint64
+	var stop uint32
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for atomic.LoadUint32(&stop) == 0 {
+			n := atomic.LoadUint64(&added)
+			for i := uint64(1); i <= n; i += 7 {
+				if has, err := r.Contains(i); err != nil || !has {
+					atomic.StoreUint32(&stop, 2)
+					return
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for i := 1; i <= cnt; i++ {
+		err := w.Add(uint64(i))
+		for err == ErrAddTooFast {
+			time.Sleep(time.Millisecond)
+			err = w.Add(uint64(i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreUint64(&added, uint64(i))
+	}
+	for w.set.isScaling() {
+		time.Sleep(time.Millisecond)
+	}
+	if !atomic.CompareAndSwapUint32(&stop, 0, 1) {
+		t.Fatal("reader missed key")
+	}
+	wg.Wait()
+
+	for i := 1; i <= cnt; i++ {
+		if !readerHas(t, r, uint64(i)) {
+			t.Fatal("reader should have key", i)
+		}
+	}
+	if readerHas(t, r, 0) || readerHas(t, r, uint64(cnt+1)) {
+		t.Fatal("reader should not have key")
+	}
+	if total, usage := r.GetUsage(); total != 8192 || usage != cnt {
+		t.Fatal("usage mismatched", total, usage)
+	}
+
+	_ = w.Add(0)
+	w.Remove(1)
+	if !readerHas(t, r, 0) || readerHas(t, r, 1) {
+		t.Fatal("reader should see the change")
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.IsRunning() || readerHas(t, r, 2) {
+		t.Fatal("reader should see it's closed")
+	}
+}
+
+func TestSharedSet_Process(t *testing.T) {
+
+	if path := os.Getenv("U64_SHARED_PATH"); path != "" {
+		runSharedReaderProcess(path)
+		return
+	}
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	w, path := newTestSharedSet(t, 2048)
+	defer w.Close()
+	cnt := 1024
+	for i := 1; i <= cnt; i++ {
+		if err := w.Add(uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSharedSet_Process$")
+	cmd.Env = append(os.Environ(), "U64_SHARED_PATH="+path, "U64_SHARED_CNT="+strconv.Itoa(cnt))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatal("reader process failed", err, string(out))
+	}
+}
+
+// runSharedReaderProcess checks keys in another process, exits 1 if failed.
+func runSharedReaderProcess(path string) {
+	cnt, _ := strconv.Atoi(os.Getenv("U64_SHARED_CNT"))
+	r, err := OpenSharedReader(path)
+	if err != nil {
+		os.Exit(1)
+	}
+	for i := 1; i <= cnt; i++ {
+		if has, err := r.Contains(uint64(i)); err != nil || !has {
+			os.Exit(1)
+		}
+	}
+	if has, err := r.Contains(uint64(cnt + 1)); err != nil || has {
+		os.Exit(1)
+	}
+	_ = r.Close()
+}
+
+func TestOpenSharedReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "set")
+	if err := os.WriteFile(path, make([]byte, os.Getpagesize()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := OpenSharedReader(path)
+	if err == ErrUnsupported {
+		t.Skip(err.Error())
+	}
+	if err != ErrInvalidShared {
+		t.Fatal("should be invalid", err)
+	}
+}
+
+func TestSharedReader_Broken(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	w, path := newTestSharedSet(t, 2048)
+	defer w.Close()
+	if err := w.Add(1); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenSharedReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Forge a descriptor which can't be mapped (0 slots).
+	idx := getWritableIdxByStatus(r.loadStatus())
+	off := sharedTblOff + int(idx)*8
+	desc := atomic.LoadUint64(hdrWord(w.hdr, off))
+	atomic.StoreUint64(hdrWord(w.hdr, off), 1<<sharedSlotsBits)
+	if has, err := r.Contains(1); err == nil || has {
+		t.Fatal("should be broken", has, err)
+	}
+
+	atomic.StoreUint64(hdrWord(w.hdr, off), desc)
+	if _, err = r.Contains(1); err == nil {
+		t.Fatal("should be still broken")
+	}
+	if err = r.Refresh(); err == nil {
+		t.Fatal("should be still broken")
+	}
+}
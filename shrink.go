@@ -0,0 +1,137 @@
+package u64
+
+import (
+	"sync"
+	"time"
+)
+
+// ShrinkPolicy decides whether a Set should be shrunk,
+// it's consulted by ShrinkManager periodically with Set's GetUsage.
+//
+// Policy may have state (e.g. LowUsage), don't share one among Sets.
+type ShrinkPolicy interface {
+	ShouldShrink(total, usage int) bool
+}
+
+// LowUsage returns a ShrinkPolicy which wants shrinking
+// when usage is below ratio of total for checks times in a row.
+func LowUsage(ratio float64, checks int) ShrinkPolicy {
+	if checks < 1 {
+		checks = 1
+	}
+	return &lowUsage{ratio: ratio, checks: checks}
+}
+
+type lowUsage struct {
+	ratio  float64
+	checks int
+	low    int
+}
+
+func (p *lowUsage) ShouldShrink(total, usage int) bool {
+	if total == 0 || float64(usage) >= float64(total)*p.ratio {
+		p.low = 0
+		return false
+	}
+	p.low++
+	if p.low < p.checks {
+		return false
+	}
+	p.low = 0
+	return true
+}
+
+// Idle returns a ShrinkPolicy which wants shrinking
+// when Set hasn't been modified for d.
+// lastModified is supplied by the caller (e.g. the time of the last Add/Remove it made),
+// Set won't get clock for it.
+func Idle(d time.Duration, lastModified func() time.Time) ShrinkPolicy {
+	return &idle{d: d, lastModified: lastModified}
+}
+
+type idle struct {
+	d            time.Duration
+	lastModified func() time.Time
+}
+
+func (p *idle) ShouldShrink(_, _ int) bool {
+	return time.Since(p.lastModified()) >= p.d
+}
+
+// ShrinkManager checks Sets periodically, and shrinks the ones which their policies want.
+//
+// It's optional, there is no auto-shrinking without it.
+type ShrinkManager struct {
+	mu   sync.Mutex
+	sets map[*Set]ShrinkPolicy
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewShrinkManager creates a ShrinkManager which checks Sets every interval.
+func NewShrinkManager(interval time.Duration) *ShrinkManager {
+	m := &ShrinkManager{
+		sets: make(map[*Set]ShrinkPolicy),
+		done: make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go m.loop(interval)
+	return m
+}
+
+// Register registers s with policy p, it replaces the old policy if s is registered.
+func (m *ShrinkManager) Register(s *Set, p ShrinkPolicy) {
+	m.mu.Lock()
+	m.sets[s] = p
+	m.mu.Unlock()
+}
+
+// Unregister unregisters s.
+// Closed Sets are unregistered automatically.
+func (m *ShrinkManager) Unregister(s *Set) {
+	m.mu.Lock()
+	delete(m.sets, s)
+	m.mu.Unlock()
+}
+
+func (m *ShrinkManager) loop(interval time.Duration) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+// check consults all policies once.
+func (m *ShrinkManager) check() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for s, p := range m.sets {
+		if !s.IsRunning() {
+			delete(m.sets, s)
+			continue
+		}
+		if p.ShouldShrink(s.GetUsage()) {
+			_ = s.Shrink() // Try it next time if failed.
+		}
+	}
+}
+
+// Close stops ShrinkManager, it won't close the registered Sets.
+func (m *ShrinkManager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.wg.Wait()
+	})
+}
@@ -0,0 +1,250 @@
+package u64
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSet_Shrink(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	s, _ := New(4096)
+	defer s.Close()
+
+	cnt := 100
+	for i := 0; i < cnt; i++ {
+		if err := s.Add(uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.scale()
+	if err := s.Shrink(); err != ErrIsScaling {
+		t.Fatal("should be scaling", err)
+	}
+	s.unScale()
+
+	exp := 4096
+	for {
+		err := s.Shrink()
+		if err == ErrCannotShrink {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for s.isScaling() {
+			time.Sleep(time.Millisecond)
+		}
+		exp /= 2
+		total, usage := s.GetUsage()
+		if total != exp || usage != cnt-1 {
+			t.Fatal("usage mismatched", total, usage, exp)
+		}
+		for i := 0; i < cnt; i++ {
+			if !s.Contains(uint64(i)) {
+				t.Fatal("should have key", i)
+			}
+		}
+	}
+	if exp != 256 {
+		t.Fatal("should be shrunk to 256", exp)
+	}
+}
+
+func TestLowUsage(t *testing.T) {
+	p := LowUsage(0.25, 3)
+	for i, c := range []struct {
+		usage int
+		exp   bool
+	}{{10, false}, {10, false}, {30, false}, {10, false}, {10, false}, {10, true}, {10, false}} {
+		if p.ShouldShrink(100, c.usage) != c.exp {
+			t.Fatal("mismatched", i)
+		}
+	}
+}
+
+func TestIdle(t *testing.T) {
+	last := time.Now()
+	p := Idle(time.Hour, func() time.Time { return last })
+	if p.ShouldShrink(100, 1) {
+		t.Fatal("should not shrink")
+	}
+	last = last.Add(-2 * time.Hour)
+	if !p.ShouldShrink(100, 1) {
+		t.Fatal("should shrink")
+	}
+}
+
+func TestShrinkManager(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	s, _ := New(4096)
+	defer s.Close()
+	_ = s.Add(1)
+
+	m := NewShrinkManager(time.Millisecond)
+	defer m.Close()
+	m.Register(s, LowUsage(0.1, 1))
+
+	for i := 0; i < 1000; i++ {
+		if total, _ := s.GetUsage(); total == 8 { // 1/8 isn't low usage.
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m.Unregister(s)
+
+	total, _ := s.GetUsage()
+	if total != 8 || !s.Contains(1) {
+		t.Fatal("should be shrunk", total)
+	}
+}
+
+// holdObserver holds the moving of shrinking in OnExpandStart until hold is closed.
+type holdObserver struct {
+	testObserver
+	hold chan struct{}
+}
+
+func (o *holdObserver) OnExpandStart(e ExpandEvent) {
+	o.testObserver.OnExpandStart(e)
+	if e.NewCap < e.OldCap {
+		<-o.hold
+	}
+}
+
+func TestSet_ShrinkAbort(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	o := &holdObserver{hold: make(chan struct{})}
+	s, _ := New(4096, WithObserver(o))
+	defer s.Close()
+
+	cnt := 1000
+	for i := 1; i <= cnt; i++ {
+		if err := s.Add(uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Shrink(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the new table before moving, keys in the old one can't be moved in.
+	for {
+		err := s.Add(uint64(cnt + 1))
+		if err == ErrAddTooFast {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		cnt++
+	}
+	close(o.hold)
+	for s.isScaling() {
+		time.Sleep(time.Millisecond)
+	}
+
+	o.mu.Lock()
+	if len(o.done) != 1 || o.done[0].Err != ErrShrinkAborted || len(o.seal) != 0 {
+		t.Fatal("should be aborted", o.done, o.seal)
+	}
+	o.mu.Unlock()
+	if s.isSealed() {
+		t.Fatal("should not be sealed")
+	}
+	total, usage := s.GetUsage()
+	if total != 4096 || usage != cnt {
+		t.Fatal("usage mismatched", total, usage, cnt)
+	}
+	for i := 1; i <= cnt; i++ {
+		if !s.Contains(uint64(i)) {
+			t.Fatal("should have key", i)
+		}
+	}
+
+	// Still writable, and it could grow.
+	for i := cnt + 1; i <= 8192; i++ {
+		err := s.Add(uint64(i))
+		for err == ErrAddTooFast {
+			time.Sleep(time.Millisecond)
+			err = s.Add(uint64(i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 8192; i++ {
+		if !s.Contains(uint64(i)) {
+			t.Fatal("should have key", i)
+		}
+	}
+}
+
+func TestSet_ShrinkConcurrentAdd(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	for round := 0; round < 16; round++ {
+		s, _ := New(4096)
+
+		start, cnt := 1000, 5000
+		for i := 1; i <= start; i++ {
+			if err := s.Add(uint64(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		errs := make(chan error, 1)
+		go func() {
+			for i := start + 1; i <= cnt; i++ {
+				err := s.Add(uint64(i))
+				for err == ErrAddTooFast {
+					runtime.Gosched()
+					err = s.Add(uint64(i))
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+		if err := s.Shrink(); err != nil && err != ErrCannotShrink && err != ErrIsScaling {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(round, err)
+		}
+		for s.isScaling() {
+			time.Sleep(time.Millisecond)
+		}
+
+		if s.isSealed() {
+			t.Fatal(round, "should not be sealed")
+		}
+		if _, usage := s.GetUsage(); usage != cnt {
+			t.Fatal(round, "usage mismatched", usage, cnt)
+		}
+		for i := 1; i <= cnt; i++ {
+			if !s.Contains(uint64(i)) {
+				t.Fatal(round, "should have key", i)
+			}
+		}
+		s.Close()
+	}
+}
@@ -27,10 +27,17 @@ func (s *Set) IsRunning() bool {
 }
 
 // close sets status closed.
+// It's under the write lock, so nothing could be started by writers after it.
 func (s *Set) close() {
+restart:
+	if !s.lock() {
+		pause()
+		goto restart
+	}
 	sa := atomic.LoadUint64(&s.status)
 	sa = clrBit(sa, 63)
 	atomic.StoreUint64(&s.status, sa)
+	s.unlock()
 }
 
 // lock tries to lock Set, return true if succeed.
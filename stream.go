@@ -0,0 +1,187 @@
+package u64
+
+import "sync/atomic"
+
+// Op is the operation of Mutation.
+type Op uint8
+
+const (
+	// OpAdd is adding a key which was absent.
+	OpAdd Op = iota + 1
+	// OpRemove is removing a key which was present.
+	OpRemove
+	// OpGap is the last Mutation of a dropped Subscription,
+	// mutations from its Seq are lost.
+	OpGap
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpAdd:
+		return "add"
+	case OpRemove:
+		return "remove"
+	case OpGap:
+		return "gap"
+	default:
+		return "unknown"
+	}
+}
+
+// Mutation is a change of Set.
+type Mutation struct {
+	Op  Op
+	Key uint64
+	// Seq is the sequence number of the mutation, it's continuous in a Set (starts at 1).
+	Seq uint64
+}
+
+// Subscription receives mutations of Set in order, it's made by Set.Subscribe.
+//
+// Mutations are sent under the write lock without blocking,
+// when the buffer is full, the subscriber is dropped:
+// an OpGap Mutation (Seq is the first lost one) is sent, and the channel is closed.
+// The subscriber should resync (e.g. from a snapshot) after that.
+//
+// Mutations made by expanding/shrinking (moving keys) aren't sent, they're not changes of the key set.
+type Subscription struct {
+	s    *Set
+	ch   chan Mutation
+	size int
+	from uint64
+}
+
+// Subscribe subscribes mutations made after it,
+// bufferSize is the count of mutations which could be buffered.
+// It returns ErrIsClosed after Close.
+func (s *Set) Subscribe(bufferSize int) (*Subscription, error) {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	sub := &Subscription{
+		s:    s,
+		ch:   make(chan Mutation, bufferSize+1), // One more for OpGap.
+		size: bufferSize,
+	}
+
+restart:
+	if !s.lock() {
+		pause()
+		goto restart
+	}
+	if !s.IsRunning() {
+		s.unlock()
+		return nil, ErrIsClosed
+	}
+	sub.from = atomic.LoadUint64(&s.seq)
+	s.subs = append(s.subs, sub)
+	s.unlock()
+	return sub, nil
+}
+
+// Seq returns the sequence number of the last mutation.
+func (s *Set) Seq() uint64 {
+	return atomic.LoadUint64(&s.seq)
+}
+
+// Snapshot returns all keys (in no particular order) & Seq at the same point.
+// Unlike Range, it's consistent: keys are collected under the write lock,
+// writers (and expanding) wait until it returns.
+//
+// It returns nothing after Close.
+func (s *Set) Snapshot() (keys []uint64, seq uint64) {
+
+restart:
+	if !s.lock() {
+		pause()
+		goto restart
+	}
+	defer s.unlock()
+
+	if !s.IsRunning() {
+		return nil, 0
+	}
+
+	keys = make([]uint64, 0, s.getCnt()+1)
+	s.Range(func(key uint64) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys, atomic.LoadUint64(&s.seq)
+}
+
+// C returns the channel of mutations,
+// it's closed after the Subscription is dropped or closed, or Set is closed.
+func (sub *Subscription) C() <-chan Mutation {
+	return sub.ch
+}
+
+// From returns Set's Seq when subscribing,
+// the first Mutation's Seq is From()+1.
+func (sub *Subscription) From() uint64 {
+	return sub.from
+}
+
+// Close unsubscribes, the channel is closed.
+func (sub *Subscription) Close() {
+	s := sub.s
+
+restart:
+	if !s.lock() {
+		pause()
+		goto restart
+	}
+	for i, x := range s.subs {
+		if x == sub {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			close(sub.ch)
+			break
+		}
+	}
+	s.unlock()
+}
+
+// publish makes a new Mutation, updates digest and sends it to subscribers.
+// Set must be locked.
+func (s *Set) publish(op Op, key uint64) {
+	seq := atomic.AddUint64(&s.seq, 1)
+	if op == OpAdd {
+		s.digest.add(key)
+	} else {
+		s.digest.remove(key)
+	}
+	if len(s.subs) == 0 {
+		return
+	}
+
+	m := Mutation{Op: op, Key: key, Seq: seq}
+	kept := s.subs[:0]
+	for _, sub := range s.subs {
+		if len(sub.ch) < sub.size { // Only one sender (under the lock).
+			sub.ch <- m
+			kept = append(kept, sub)
+			continue
+		}
+		sub.ch <- Mutation{Op: OpGap, Seq: seq}
+		close(sub.ch)
+	}
+	for i := len(kept); i < len(s.subs); i++ {
+		s.subs[i] = nil
+	}
+	s.subs = kept
+}
+
+// closeSubs closes all subscriptions, Set must be closed.
+func (s *Set) closeSubs() {
+
+restart:
+	if !s.lock() {
+		pause()
+		goto restart
+	}
+	for _, sub := range s.subs {
+		close(sub.ch)
+	}
+	s.subs = nil
+	s.unlock()
+}
@@ -0,0 +1,179 @@
+package u64
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// addKey adds key into s, waiting for expanding if it's too fast.
+func addKey(t *testing.T, s *Set, key uint64) {
+	t.Helper()
+
+	err := s.Add(key)
+	for err == ErrAddTooFast {
+		time.Sleep(time.Millisecond)
+		err = s.Add(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSet_Subscribe(t *testing.T) {
+
+	s, _ := New(2048)
+	defer s.Close()
+
+	_ = s.Add(1)
+	sub, err := s.Subscribe(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.From() != 1 || s.Seq() != 1 {
+		t.Fatal("seq mismatched", sub.From(), s.Seq())
+	}
+
+	r, _ := New(2048) // Replica.
+	defer r.Close()
+	_ = r.Add(1)
+
+	n := uint64(1024)
+	for i := uint64(0); i < n; i++ {
+		addKey(t, s, i)
+	}
+	_ = s.Add(3)    // Existed, no mutation.
+	s.Remove(n + 1) // Absent, no mutation.
+	for i := uint64(0); i < n; i += 2 {
+		s.Remove(i)
+	}
+
+	want := sub.From() + 1
+	for s.Seq() >= want {
+		m := <-sub.C()
+		if m.Seq != want {
+			t.Fatal("seq mismatched", m.Seq, want)
+		}
+		want++
+		switch m.Op {
+		case OpAdd:
+			addKey(t, r, m.Key)
+		case OpRemove:
+			r.Remove(m.Key)
+		default:
+			t.Fatal("unexpected op", m.Op)
+		}
+	}
+	if s.Seq() != 1+n-1+n/2 { // Key 1 was added before.
+		t.Fatal("seq mismatched", s.Seq())
+	}
+
+	for i := uint64(0); i <= n; i++ {
+		if s.Contains(i) != r.Contains(i) {
+			t.Fatal("replica mismatched", i)
+		}
+	}
+
+	sub.Close()
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("should be closed")
+	}
+	sub.Close() // Closing twice is fine.
+}
+
+func TestSet_SubscribeGap(t *testing.T) {
+
+	s, _ := New(1024)
+
+	slow, _ := s.Subscribe(2)
+	fast, _ := s.Subscribe(16)
+	for i := uint64(1); i <= 4; i++ {
+		_ = s.Add(i)
+	}
+
+	var ms []Mutation
+	for m := range slow.C() {
+		ms = append(ms, m)
+	}
+	if len(ms) != 3 || ms[1].Seq != 2 || ms[2].Op != OpGap || ms[2].Seq != 3 {
+		t.Fatal("gap mismatched", ms)
+	}
+
+	s.Close()
+	cnt := 0
+	for range fast.C() { // Closed by Set.Close.
+		cnt++
+	}
+	if cnt != 4 {
+		t.Fatal("fast subscriber should have all", cnt)
+	}
+	if _, err := s.Subscribe(1); err != ErrIsClosed {
+		t.Fatal("should be closed", err)
+	}
+}
+
+func TestSet_Snapshot(t *testing.T) {
+
+	s, _ := New(2048)
+
+	done := make(chan error, 1)
+	go func() {
+		for i := uint64(0); i < 4096; i++ {
+			err := s.Add(i)
+			for err == ErrAddTooFast {
+				runtime.Gosched()
+				err = s.Add(i)
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+			if i%3 == 0 {
+				s.Remove(i / 2)
+			}
+		}
+		done <- nil
+	}()
+
+	for i := 0; i < 16; i++ {
+		keys, seq := s.Snapshot()
+		_, usage := s.GetUsage()
+		if seq == 0 && len(keys) != 0 {
+			t.Fatal("seq mismatched")
+		}
+		has := map[uint64]bool{}
+		for _, k := range keys {
+			if has[k] {
+				t.Fatal("duplicated key", k)
+			}
+			has[k] = true
+		}
+		n := len(keys)
+		if has[0] {
+			n--
+		}
+		if n > usage+1 { // usage may be changed after Snapshot, but only by one Add.
+			t.Fatal("usage mismatched", n, usage)
+		}
+		runtime.Gosched()
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	keys, seq := s.Snapshot()
+	_, usage := s.GetUsage()
+	if seq != s.Seq() || len(keys) != usage { // Key 0 is removed.
+		t.Fatal("mismatched", seq, len(keys), usage)
+	}
+	for _, k := range keys {
+		if !s.Contains(k) {
+			t.Fatal("should have", k)
+		}
+	}
+
+	s.Close()
+	if keys, _ = s.Snapshot(); keys != nil {
+		t.Fatal("should be empty after Close")
+	}
+}
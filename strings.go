@@ -0,0 +1,295 @@
+package u64
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/templexxx/xxh3"
+)
+
+// StringSet is a string set, it's made of a kvSet & an arena:
+// kvSet maps the 64-bit xxh3 digest of string to its position in arena,
+// arena keeps the original bytes for verifying digest collisions.
+//
+// Contains is lock-free: the digest lookup is the same as Set.Contains,
+// and bytes in arena are never overwritten, it only retries when the ref is out of date (see compact).
+//
+// Two different strings which have the same digest can't be in StringSet together,
+// Add returns ErrCollision for the latter one (it's about n^2/2^65 for n strings).
+//
+// Bytes of removed strings are reclaimed by compact when arena needs a new chunk
+// and at least half of it is dead.
+type StringSet struct {
+	kv *kvSet
+	a  arena
+}
+
+var ErrCollision = errors.New("digest collision")
+
+// NewStringSet creates a new StringSet.
+// cap is the set capacity at the beginning, see New for details.
+func NewStringSet(cap int) (*StringSet, error) {
+	s := &StringSet{kv: newKVSet(cap)}
+	s.a.init()
+	return s, nil
+}
+
+// Add adds str into StringSet.
+// Return nil if succeed.
+func (s *StringSet) Add(str string) error {
+	_, err := s.TryAdd(str)
+	return err
+}
+
+// TryAdd adds str into StringSet like Add,
+// and reports whether str was absent before (added is true).
+func (s *StringSet) TryAdd(str string) (added bool, err error) {
+
+	collided := false
+	err = s.kv.update(xxh3.HashString(str), func(ref uint64, ok bool) (uint64, bool) {
+		if ok {
+			b, _ := s.a.get(ref) // Refs are up to date under the write lock.
+			collided = string(b) != str
+			return ref, true
+		}
+		added = true
+		if s.a.shouldCompact(str) {
+			s.compact()
+		}
+		return s.a.append(str), true // It's under the write lock.
+	})
+	if err != nil {
+		return false, err
+	}
+	if collided {
+		return false, ErrCollision
+	}
+	return added, nil
+}
+
+// Contains returns the str in set or not.
+func (s *StringSet) Contains(str string) bool {
+	b, ok := s.load(xxh3.HashString(str))
+	return ok && string(b) == str
+}
+
+// ContainsBytes returns the b in set or not, it works as Contains(string(b)).
+func (s *StringSet) ContainsBytes(b []byte) bool {
+	v, ok := s.load(xxh3.Hash(b))
+	return ok && string(v) == string(b)
+}
+
+// load loads the bytes which digest is d, ok is false if there is no such digest.
+// It retries when the ref is out of date (its chunk has been reclaimed by compact).
+func (s *StringSet) load(d uint64) (b []byte, ok bool) {
+	for {
+		ref, has := s.kv.get(d)
+		if !has {
+			return nil, false
+		}
+		if b, ok = s.a.get(ref); ok {
+			return b, true
+		}
+	}
+}
+
+// Remove removes str in StringSet.
+func (s *StringSet) Remove(str string) {
+	_ = s.kv.update(xxh3.HashString(str), func(ref uint64, ok bool) (uint64, bool) {
+		if !ok {
+			return 0, false
+		}
+		if b, _ := s.a.get(ref); string(b) != str {
+			return ref, true // Keep the collided one.
+		}
+		s.a.free(ref)
+		return 0, false
+	})
+}
+
+// Range calls f sequentially for each string present in the StringSet.
+// If f returns false, range stops the iteration.
+//
+// Range has the same consistency as Set.Range.
+func (s *StringSet) Range(f func(str string) bool) {
+	s.kv.rangeKV(func(d, ref uint64) bool {
+		b, ok := s.a.get(ref)
+		if !ok {
+			if b, ok = s.load(d); !ok {
+				return true // Removed.
+			}
+		}
+		return f(string(b))
+	})
+}
+
+// GetUsage returns StringSet capacity & usage.
+func (s *StringSet) GetUsage() (total, usage int) {
+	return s.kv.getUsage()
+}
+
+// compact moves alive strings into new chunks and reclaims the old chunks,
+// it must be called under the write lock.
+//
+// New chunks are published before refs in kvSet are updated,
+// so readers which get a new ref could always find its bytes,
+// and readers which get an old ref after the reclaiming will retry (see load).
+func (s *StringSet) compact() {
+
+	n := s.a.cut()
+
+	h := s.kv.tables()
+	widx := s.kv.set.getWritableIdx()
+	wt := h.table(widx)
+	for _, idx := range [2]uint8{widx, widx ^ 1} {
+		t := h.table(idx)
+		if t == nil {
+			continue
+		}
+		for i := 0; i < t.slots(); i++ {
+			p := t.load(i)
+			if p.key == 0 {
+				continue
+			}
+			if idx != widx {
+				// It's in both tables when it's being moved by expand, sharing the new ref.
+				if has, pos := h.find(wt, widx, p); has {
+					t.store(i, wt.load(pos))
+					continue
+				}
+			}
+			b, _ := s.a.get(p.val)
+			t.store(i, kvPair{key: p.key, val: s.a.append(string(b))})
+		}
+	}
+	s.a.reclaim(n)
+}
+
+// IsRunning returns StringSet is running or not.
+func (s *StringSet) IsRunning() bool {
+	return s.kv.IsRunning()
+}
+
+// Close closes StringSet and release the resource.
+func (s *StringSet) Close() {
+	s.kv.Close()
+}
+
+// arenaChunkSize is the size of each chunk in arena,
+// string which is bigger than it has its own chunk.
+const arenaChunkSize = 64 << 10
+
+// arena is a bytes container, bytes are never overwritten after appending.
+// Each string is | uvarint length | bytes |,
+// and its ref is chunk_index << 32 | offset_in_chunk.
+//
+// Chunks are reclaimed (set to nil, indexes are kept) after moving alive strings out (see StringSet.compact).
+//
+// append, free, cut & reclaim must be called under the write lock,
+// get is wait-free: bytes are written before the ref is stored into kvSet.
+type arena struct {
+	// chunks is *[][]byte, it's replaced (copy on write) when adding or reclaiming chunks.
+	chunks unsafe.Pointer
+	// off is the next writing position in the last chunk.
+	off int
+	// live is the bytes of alive strings, dead is the bytes of freed ones.
+	live, dead int
+}
+
+func (a *arena) init() {
+	chunks := make([][]byte, 0)
+	a.chunks = unsafe.Pointer(&chunks)
+}
+
+func (a *arena) loadChunks() [][]byte {
+	return *(*[][]byte)(atomic.LoadPointer(&a.chunks))
+}
+
+// append appends str into arena, and returns its ref.
+func (a *arena) append(str string) uint64 {
+
+	n := uvarintSize(uint64(len(str))) + len(str)
+
+	chunks := a.loadChunks()
+	if len(chunks) == 0 || a.off+n > len(chunks[len(chunks)-1]) {
+		size := arenaChunkSize
+		if n > size {
+			size = n
+		}
+		next := make([][]byte, len(chunks)+1)
+		copy(next, chunks)
+		next[len(chunks)] = make([]byte, size)
+		atomic.StorePointer(&a.chunks, unsafe.Pointer(&next))
+		chunks = next
+		a.off = 0
+	}
+
+	ci := len(chunks) - 1
+	c := chunks[ci][a.off:]
+	m := binary.PutUvarint(c, uint64(len(str)))
+	copy(c[m:], str)
+
+	ref := uint64(ci)<<32 | uint64(a.off)
+	a.off += n
+	a.live += n
+	return ref
+}
+
+// get gets bytes by ref, ok is false if its chunk has been reclaimed.
+func (a *arena) get(ref uint64) (b []byte, ok bool) {
+	c := a.loadChunks()[ref>>32]
+	if c == nil {
+		return nil, false
+	}
+	c = c[uint32(ref):]
+	l, m := binary.Uvarint(c)
+	return c[m : m+int(l)], true
+}
+
+// free marks the string of ref dead.
+func (a *arena) free(ref uint64) {
+	b, _ := a.get(ref)
+	n := uvarintSize(uint64(len(b))) + len(b)
+	a.live -= n
+	a.dead += n
+}
+
+// shouldCompact returns true if appending str needs a new chunk,
+// and the dead bytes are more than a chunk & the alive ones.
+func (a *arena) shouldCompact(str string) bool {
+	if a.dead < arenaChunkSize || a.dead < a.live {
+		return false
+	}
+	chunks := a.loadChunks()
+	return len(chunks) > 0 && a.off+uvarintSize(uint64(len(str)))+len(str) > len(chunks[len(chunks)-1])
+}
+
+// cut makes the next append start a new chunk, and returns the count of chunks before it.
+// Alive bytes are counted again by append after that.
+func (a *arena) cut() int {
+	chunks := a.loadChunks()
+	if len(chunks) > 0 {
+		a.off = len(chunks[len(chunks)-1])
+	}
+	a.live = 0
+	return len(chunks)
+}
+
+// reclaim reclaims the first n chunks.
+func (a *arena) reclaim(n int) {
+	chunks := a.loadChunks()
+	next := make([][]byte, len(chunks))
+	copy(next[n:], chunks[n:])
+	atomic.StorePointer(&a.chunks, unsafe.Pointer(&next))
+	a.dead = 0
+}
+
+func uvarintSize(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+	return n
+}
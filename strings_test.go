@@ -0,0 +1,214 @@
+package u64
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/templexxx/xxh3"
+)
+
+func TestStringSet(t *testing.T) {
+
+	cnt := 3 << 11
+	s, err := NewStringSet(4096) // Not enough capacity, must trigger expand.
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	str := func(i int) string {
+		return "https://example.com/" + strconv.Itoa(i)
+	}
+
+	for i := 0; i < cnt; i++ {
+		added, err := s.TryAdd(str(i))
+		for err == ErrAddTooFast { // Waiting for expanding.
+			time.Sleep(time.Millisecond)
+			added, err = s.TryAdd(str(i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added {
+			t.Fatal("should be added", i)
+		}
+	}
+	if added, err := s.TryAdd(str(1)); err != nil || added {
+		t.Fatal("should be existed", err)
+	}
+	for s.kv.set.isScaling() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < cnt; i++ {
+		if !s.Contains(str(i)) || !s.ContainsBytes([]byte(str(i))) {
+			t.Fatal("should have str", i)
+		}
+	}
+	if s.Contains(str(cnt)) {
+		t.Fatal("should not have str")
+	}
+
+	seen := make(map[string]bool, cnt)
+	s.Range(func(str string) bool {
+		seen[str] = true
+		return true
+	})
+	if len(seen) != cnt {
+		t.Fatal("range count mismatched", len(seen))
+	}
+
+	for i := 0; i < cnt; i++ {
+		s.Remove(str(i))
+		if s.Contains(str(i)) {
+			t.Fatal("should not have str", i)
+		}
+	}
+	_, usage := s.GetUsage()
+	if usage != 0 {
+		t.Fatal("usage mismatched", usage)
+	}
+}
+
+func TestStringSet_Collision(t *testing.T) {
+
+	s, err := NewStringSet(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Fake a collision: "b" has the same digest as "a".
+	err = s.kv.update(xxh3.HashString("b"), func(_ uint64, _ bool) (uint64, bool) {
+		return s.a.append("a"), true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Contains("b") {
+		t.Fatal("should not have collided str")
+	}
+	if _, err = s.TryAdd("b"); err != ErrCollision {
+		t.Fatal("should be collision", err)
+	}
+	s.Remove("b")
+	if _, usage := s.GetUsage(); usage != 1 {
+		t.Fatal("collided one should not be removed", usage)
+	}
+}
+
+func TestArena(t *testing.T) {
+
+	var a arena
+	a.init()
+
+	strs := []string{"", "a", strings.Repeat("b", arenaChunkSize*2), "c", strings.Repeat("d", arenaChunkSize-3)}
+	refs := make([]uint64, len(strs))
+	for i, str := range strs {
+		refs[i] = a.append(str)
+	}
+	for i, str := range strs {
+		if b, ok := a.get(refs[i]); !ok || string(b) != str {
+			t.Fatal("mismatched", i)
+		}
+	}
+
+	n := a.cut()
+	ref := a.append("e")
+	a.reclaim(n)
+	if _, ok := a.get(refs[0]); ok {
+		t.Fatal("should be reclaimed")
+	}
+	if b, ok := a.get(ref); !ok || string(b) != "e" {
+		t.Fatal("mismatched after reclaiming")
+	}
+}
+
+func TestStringSet_Reclaim(t *testing.T) {
+
+	s, err := NewStringSet(8192) // Big enough, compacting is tested without expanding.
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	str := func(round, i int) string {
+		return strings.Repeat("x", 100) + strconv.Itoa(round) + "/" + strconv.Itoa(i)
+	}
+	add := func(str string) {
+		err := s.Add(str)
+		for err == ErrAddTooFast { // Waiting for expanding.
+			time.Sleep(time.Millisecond)
+			err = s.Add(str)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kept := 1000
+	for i := 0; i < kept; i++ {
+		add(str(-1, i))
+	}
+
+	// Readers must always see kept strings during compacting.
+	done := make(chan struct{})
+	missed := make(chan int, 1)
+	go func() {
+		for {
+			select {
+			case <-done:
+				close(missed)
+				return
+			default:
+			}
+			for i := 0; i < kept; i++ {
+				if !s.Contains(str(-1, i)) {
+					missed <- i
+					close(missed)
+					return
+				}
+			}
+		}
+	}()
+
+	cnt := 2000
+	for round := 0; round < 32; round++ {
+		for i := 0; i < cnt; i++ {
+			add(str(round, i))
+		}
+		for i := 0; i < cnt; i++ {
+			s.Remove(str(round, i))
+		}
+	}
+	close(done)
+	if i, ok := <-missed; ok {
+		t.Fatal("should have kept str", i)
+	}
+
+	for i := 0; i < kept; i++ {
+		if !s.Contains(str(-1, i)) {
+			t.Fatal("should have kept str", i)
+		}
+	}
+	if s.Contains(str(0, 0)) {
+		t.Fatal("should not have removed str")
+	}
+	if _, usage := s.GetUsage(); usage != kept {
+		t.Fatal("usage mismatched", usage)
+	}
+
+	chunks := 0
+	for _, c := range s.a.loadChunks() {
+		if c != nil {
+			chunks++
+		}
+	}
+	// Without reclaiming, it's about 32 * 2000 * 110 / 64KB = 107 chunks.
+	if chunks > 16 {
+		t.Fatal("too many chunks", chunks)
+	}
+}
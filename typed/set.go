@@ -0,0 +1,94 @@
+// Package typed provides u64.Set over other integer key types,
+// it saves the casting between user's IDs (e.g. int64, uint32) and uint64.
+package typed
+
+import (
+	"github.com/templexxx/u64"
+)
+
+// Key is the integer types which could be the key of Set.
+type Key interface {
+	~uint64 | ~int64 | ~uint32 | ~int32
+}
+
+// Set is a u64.Set over key type K.
+//
+// Keys are mapped to uint64 by conversion (signed types are sign-extended),
+// it's bijective between K and its image in uint64,
+// so negative keys & the zero key all work as u64.Set.
+type Set[K Key] struct {
+	s *u64.Set
+}
+
+// New creates a new Set, see u64.New for details.
+func New[K Key](cap int, opts ...u64.Option) (*Set[K], error) {
+	s, err := u64.New(cap, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Set[K]{s: s}, nil
+}
+
+// Wrap wraps an existed u64.Set as Set over K,
+// keys in s should be made by the same mapping.
+func Wrap[K Key](s *u64.Set) *Set[K] {
+	return &Set[K]{s: s}
+}
+
+// Unwrap returns the underlying u64.Set.
+func (s *Set[K]) Unwrap() *u64.Set {
+	return s.s
+}
+
+func toU64[K Key](key K) uint64 {
+	return uint64(key)
+}
+
+// Add adds key into Set, see u64.Set.Add for details.
+func (s *Set[K]) Add(key K) error {
+	return s.s.Add(toU64(key))
+}
+
+// TryAdd adds key into Set, and reports whether key was absent before,
+// see u64.Set.TryAdd for details.
+func (s *Set[K]) TryAdd(key K) (added bool, err error) {
+	return s.s.TryAdd(toU64(key))
+}
+
+// Contains returns the key in set or not.
+func (s *Set[K]) Contains(key K) bool {
+	return s.s.Contains(toU64(key))
+}
+
+// Remove removes key in Set.
+func (s *Set[K]) Remove(key K) {
+	s.s.Remove(toU64(key))
+}
+
+// Delete removes key in Set, and reports whether key was present before.
+func (s *Set[K]) Delete(key K) (removed bool) {
+	return s.s.Delete(toU64(key))
+}
+
+// Range calls f sequentially for each key present in the Set,
+// see u64.Set.Range for details.
+func (s *Set[K]) Range(f func(key K) bool) {
+	s.s.Range(func(key uint64) bool {
+		return f(K(key))
+	})
+}
+
+// GetUsage returns Set capacity & usage.
+func (s *Set[K]) GetUsage() (total, usage int) {
+	return s.s.GetUsage()
+}
+
+// IsRunning returns Set is running or not.
+func (s *Set[K]) IsRunning() bool {
+	return s.s.IsRunning()
+}
+
+// Close closes Set and release the resource.
+func (s *Set[K]) Close() {
+	s.s.Close()
+}
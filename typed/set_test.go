@@ -0,0 +1,78 @@
+package typed
+
+import (
+	"math"
+	"testing"
+)
+
+type userID int64
+
+func TestSet_Int64(t *testing.T) {
+	testSet(t, []int64{0, 1, -1, math.MinInt64, math.MaxInt64, -1024, 1024})
+}
+
+func TestSet_Int32(t *testing.T) {
+	testSet(t, []int32{0, 1, -1, math.MinInt32, math.MaxInt32, -1024, 1024})
+}
+
+func TestSet_Uint32(t *testing.T) {
+	testSet(t, []uint32{0, 1, math.MaxUint32, 1024})
+}
+
+func TestSet_Uint64(t *testing.T) {
+	testSet(t, []uint64{0, 1, math.MaxUint64, 1024})
+}
+
+func TestSet_Named(t *testing.T) {
+	testSet(t, []userID{0, 1, -1, 1024})
+}
+
+func testSet[K Key](t *testing.T, keys []K) {
+	s, err := New[K](len(keys) * 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, key := range keys {
+		added, err := s.TryAdd(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added {
+			t.Fatal("should be added", key)
+		}
+	}
+	for _, key := range keys {
+		if !s.Contains(key) {
+			t.Fatal("should have key", key)
+		}
+	}
+
+	seen := make(map[K]bool, len(keys))
+	s.Range(func(key K) bool {
+		seen[key] = true
+		return true
+	})
+	if len(seen) != len(keys) {
+		t.Fatal("range mismatched", seen)
+	}
+	for _, key := range keys {
+		if !seen[key] {
+			t.Fatal("range should have key", key)
+		}
+	}
+
+	for _, key := range keys {
+		if !s.Delete(key) {
+			t.Fatal("should be removed", key)
+		}
+		if s.Contains(key) {
+			t.Fatal("should not have key", key)
+		}
+	}
+	_, usage := s.GetUsage()
+	if usage != 0 {
+		t.Fatal("usage mismatched", usage)
+	}
+}
@@ -0,0 +1,165 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Segment is a sequence of records, there is no header.
+// The seq of the i-th record is first_seq (in file name) + i.
+//
+// Record (little endian):
+// | crc(uint32) | op(uint8) | key(uint64) |
+//
+// crc is CRC-32C of op & key.
+const (
+	recordSize = 13
+
+	opAdd    uint8 = 1
+	opRemove uint8 = 2
+)
+
+const (
+	segPrefix  = "wal-"
+	segSuffix  = ".log"
+	snapPrefix = "snap-"
+	snapSuffix = ".snap"
+	tmpSuffix  = ".tmp"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func encodeRecord(p []byte, op uint8, key uint64) {
+	p[4] = op
+	binary.LittleEndian.PutUint64(p[5:], key)
+	binary.LittleEndian.PutUint32(p, crc32.Checksum(p[4:recordSize], crcTable))
+}
+
+// decodeRecord decodes a record, ok is false if it's damaged.
+func decodeRecord(p []byte) (op uint8, key uint64, ok bool) {
+	op = p[4]
+	key = binary.LittleEndian.Uint64(p[5:])
+	ok = binary.LittleEndian.Uint32(p) == crc32.Checksum(p[4:recordSize], crcTable) &&
+		(op == opAdd || op == opRemove)
+	return
+}
+
+// readSegment reads records in segment and calls f for each one,
+// it returns the size of the valid records.
+//
+// A damaged record ends the last segment (torn write),
+// but it's ErrCorrupted in other segments.
+func readSegment(path string, first uint64, last bool, f func(seq uint64, op uint8, key uint64) error) (int64, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, 64<<10)
+	var rec [recordSize]byte
+	off, seq := int64(0), first
+	for {
+		_, err = io.ReadFull(r, rec[:])
+		if err == io.EOF {
+			return off, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return off, err
+		}
+		op, key, ok := decodeRecord(rec[:])
+		if !ok {
+			break
+		}
+		if err = f(seq, op, key); err != nil {
+			return off, err
+		}
+		off += recordSize
+		seq++
+	}
+
+	if !last {
+		return off, ErrCorrupted
+	}
+	return off, nil
+}
+
+func segPath(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%016x%s", segPrefix, first, segSuffix))
+}
+
+func snapPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%016x%s", snapPrefix, seq, snapSuffix))
+}
+
+// listFiles lists seqs of snapshots & segments in dir in ascending order,
+// and removes temporary files left by crashed snapshots.
+func listFiles(dir string) (snaps, segs []uint64, err error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case strings.HasSuffix(name, tmpSuffix):
+			if err = os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, nil, err
+			}
+		case strings.HasPrefix(name, snapPrefix) && strings.HasSuffix(name, snapSuffix):
+			if seq, ok := parseSeq(name, snapPrefix, snapSuffix); ok {
+				snaps = append(snaps, seq)
+			}
+		case strings.HasPrefix(name, segPrefix) && strings.HasSuffix(name, segSuffix):
+			if seq, ok := parseSeq(name, segPrefix, segSuffix); ok {
+				segs = append(segs, seq)
+			}
+		}
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i] < snaps[j] })
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return snaps, segs, nil
+}
+
+func parseSeq(name, prefix, suffix string) (uint64, bool) {
+	seq, err := strconv.ParseUint(name[len(prefix):len(name)-len(suffix)], 16, 64)
+	return seq, err == nil
+}
+
+// createFile creates a new file for writing, and makes its directory entry durable.
+func createFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syncDir(filepath.Dir(path)); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/templexxx/u64"
+	"github.com/templexxx/u64/interop"
+)
+
+// Snapshot file:
+// | keys in interop Sorted format | crc(uint32, little endian) |
+//
+// crc is CRC-32C of the keys part.
+
+// Snapshot writes all keys as a new snapshot, and removes the older snapshots & segments after it's durable.
+//
+// Writes are blocked while keys are being collected (it's in memory),
+// but not while the snapshot is being written.
+func (d *DurableSet) Snapshot() error {
+	d.snapMu.Lock()
+	defer d.snapMu.Unlock()
+
+	d.mu.Lock()
+	if err := d.writable(); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	if err := d.rotate(); err != nil { // Records after the snapshot begin in a new segment.
+		d.err = err
+		d.mu.Unlock()
+		return err
+	}
+	seq := d.seq
+	keys, err := d.collect()
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = writeSnapshot(snapPath(d.dir, seq), keys); err != nil {
+		return err
+	}
+	return d.compact(seq)
+}
+
+// collect returns all keys in ascending order, it's called under the lock.
+//
+// Keys are taken by Set.Snapshot, it's consistent even if keys are being moved by the expanding.
+func (d *DurableSet) collect() ([]uint64, error) {
+	keys, _ := d.set.Snapshot()
+	if keys == nil { // Set is closed, an empty snapshot would drop all keys.
+		return nil, u64.ErrIsClosed
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys, nil
+}
+
+// compact removes snapshots & segments which are covered by the snapshot at seq.
+func (d *DurableSet) compact(seq uint64) error {
+	snaps, segs, err := listFiles(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range snaps {
+		if s < seq {
+			if err = os.Remove(snapPath(d.dir, s)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, first := range segs {
+		if first <= seq { // The segment which begins at seq+1 is created by Snapshot.
+			if err = os.Remove(segPath(d.dir, first)); err != nil {
+				return err
+			}
+		}
+	}
+	return syncDir(d.dir)
+}
+
+// writeSnapshot writes keys into a temporary file, and renames it to path after fsync.
+func writeSnapshot(path string, keys []uint64) error {
+
+	tmp := path + tmpSuffix
+	f, err := createFile(tmp)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(f, 64<<10)
+	h := crc32.New(crcTable)
+	err = interop.WriteSortedKeys(io.MultiWriter(bw, h), keys)
+	if err == nil {
+		err = binary.Write(bw, binary.LittleEndian, h.Sum32())
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// readSnapshot reads snapshot and calls f for each key in ascending order.
+func readSnapshot(path string, f func(key uint64) error) error {
+
+	p, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(p) < 4 {
+		return ErrCorrupted
+	}
+	body := p[:len(p)-4]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(p[len(p)-4:]) {
+		return ErrCorrupted
+	}
+
+	r := bytes.NewReader(body)
+	err = interop.ReadSortedKeys(r, f)
+	if err == interop.ErrInvalidFormat || err == io.ErrUnexpectedEOF || err == io.EOF {
+		return ErrCorrupted
+	}
+	if err == nil && r.Len() != 0 {
+		return ErrCorrupted
+	}
+	return err
+}
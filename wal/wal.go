@@ -0,0 +1,436 @@
+// Package wal makes u64.Set durable by a write-ahead log & snapshots.
+//
+// Files in directory:
+//
+// 1. Segments: wal-<first_seq>.log, records of Add/Remove, see segment.go for the format.
+// New segment is created when the current one reaches the segment size, or a snapshot starts.
+//
+// 2. Snapshots: snap-<seq>.snap, all keys after applying records up to seq,
+// in interop Sorted format with a checksum.
+//
+// Open loads the latest snapshot and replays the records after it.
+// Segments covered by a new snapshot are removed (compaction) after the snapshot is durable.
+package wal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/templexxx/u64"
+)
+
+var (
+	// ErrCorrupted is returned by Open when a snapshot or a sealed segment is damaged.
+	// A damaged tail of the last segment is a torn write, it's truncated silently.
+	ErrCorrupted = errors.New("wal is corrupted")
+)
+
+// SyncPolicy is the fsync policy of DurableSet.
+//
+// Records are written to file (OS page cache) before Add/Remove returns in all policies,
+// so they survive process crash, the policy decides how many of them survive OS crash & power loss.
+type SyncPolicy uint8
+
+const (
+	// SyncAlways fsyncs after each record, nothing is lost.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs every sync interval (see WithSyncInterval) if there are new records,
+	// records in the last interval may be lost.
+	SyncInterval
+	// SyncNever leaves it to OS, only fsyncs when rotating segment & closing.
+	SyncNever
+)
+
+const (
+	defaultSegmentSize  = 64 << 20
+	defaultSyncInterval = 100 * time.Millisecond
+)
+
+type options struct {
+	sync         SyncPolicy
+	syncInterval time.Duration
+	segmentSize  int64
+	setOpts      []u64.Option
+}
+
+// Option configures DurableSet.
+type Option func(*options)
+
+// WithSync sets fsync policy, default is SyncAlways.
+func WithSync(p SyncPolicy) Option {
+	return func(o *options) {
+		o.sync = p
+	}
+}
+
+// WithSyncInterval sets the interval of SyncInterval, default is 100ms.
+func WithSyncInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.syncInterval = d
+	}
+}
+
+// WithSegmentSize sets the size of segment file, default is 64MiB.
+// It's a soft limit, segment is rotated after the record which reaches it.
+func WithSegmentSize(n int64) Option {
+	return func(o *options) {
+		o.segmentSize = n
+	}
+}
+
+// WithSetOptions passes opts to u64.New.
+func WithSetOptions(opts ...u64.Option) Option {
+	return func(o *options) {
+		o.setOpts = opts
+	}
+}
+
+// DurableSet is a u64.Set which logs Add/Remove in WAL.
+//
+// Writes are serialized by a mutex (the log order is the applying order),
+// Contains is still wait-free.
+//
+// A record is only logged when it changes the Set (e.g. adding an existed key isn't logged).
+// Set is changed before the record is written, if writing fails,
+// the change stays in memory but isn't durable, and DurableSet refuses writes after that.
+type DurableSet struct {
+	set  *u64.Set
+	dir  string
+	opts options
+
+	mu     sync.Mutex
+	seg    *os.File
+	segOff int64
+	seq    uint64 // seq of the last record.
+	dirty  bool   // Has records which aren't fsynced.
+	err    error  // Sticky writing error.
+	closed bool
+
+	snapMu sync.Mutex // Serializes Snapshot.
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens (creates if not existed) a DurableSet in dir.
+// cap is the set capacity at the beginning, see u64.New for details,
+// it should be enough for the recovered keys, because adding in bulk is much faster than expanding.
+func Open(dir string, cap int, opts ...Option) (*DurableSet, error) {
+
+	o := options{
+		sync:         SyncAlways,
+		syncInterval: defaultSyncInterval,
+		segmentSize:  defaultSegmentSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s, err := u64.New(cap, o.setOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DurableSet{
+		set:  s,
+		dir:  dir,
+		opts: o,
+		done: make(chan struct{}),
+	}
+	if err = d.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if o.sync == SyncInterval {
+		d.wg.Add(1)
+		go d.syncLoop()
+	}
+	return d, nil
+}
+
+// recover loads the latest snapshot, replays segments & opens the last segment for appending.
+func (d *DurableSet) recover() error {
+
+	snaps, segs, err := listFiles(d.dir)
+	if err != nil {
+		return err
+	}
+
+	var snapSeq uint64
+	if len(snaps) > 0 {
+		snapSeq = snaps[len(snaps)-1]
+		err = readSnapshot(snapPath(d.dir, snapSeq), func(key uint64) error {
+			return add(d.set, key)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	d.seq = snapSeq
+
+	for i, first := range segs {
+		last := i == len(segs)-1
+		if !last && segs[i+1] <= snapSeq+1 {
+			continue // Covered by snapshot entirely, but not removed yet.
+		}
+		if first > d.seq+1 {
+			return ErrCorrupted // Missing records.
+		}
+
+		off, err := readSegment(segPath(d.dir, first), first, last, func(seq uint64, op uint8, key uint64) error {
+			if seq <= snapSeq {
+				return nil
+			}
+			d.seq = seq
+			return apply(d.set, op, key)
+		})
+		if err != nil {
+			return err
+		}
+
+		// Append to the last segment only if it ends at seq,
+		// it may end before the snapshot if its tail is torn.
+		if last && first+uint64(off/recordSize) == d.seq+1 {
+			f, err := os.OpenFile(segPath(d.dir, first), os.O_WRONLY, 0o644)
+			if err != nil {
+				return err
+			}
+			if err = f.Truncate(off); err != nil { // Drop the torn tail.
+				_ = f.Close()
+				return err
+			}
+			if _, err = f.Seek(off, io.SeekStart); err != nil {
+				_ = f.Close()
+				return err
+			}
+			d.seg, d.segOff = f, off
+		}
+	}
+
+	if d.seg == nil || d.segOff >= d.opts.segmentSize {
+		return d.rotate()
+	}
+	return nil
+}
+
+// rotate fsyncs & closes the current segment, and creates a new one which begins at seq+1.
+// It's called under the lock (or before DurableSet is shared).
+func (d *DurableSet) rotate() error {
+	if d.seg != nil {
+		if err := d.closeSeg(); err != nil {
+			return err
+		}
+	}
+	f, err := createFile(segPath(d.dir, d.seq+1))
+	if err != nil {
+		return err
+	}
+	d.seg, d.segOff = f, 0
+	return nil
+}
+
+func (d *DurableSet) closeSeg() error {
+	err := d.seg.Sync()
+	if cerr := d.seg.Close(); err == nil {
+		err = cerr
+	}
+	d.seg, d.dirty = nil, false
+	return err
+}
+
+// Add adds key into DurableSet.
+// Return nil if succeed.
+func (d *DurableSet) Add(key uint64) error {
+	_, err := d.TryAdd(key)
+	return err
+}
+
+// TryAdd adds key into DurableSet like Add,
+// and reports whether key was absent before (added is true).
+//
+// Errors of u64.Set (e.g. u64.ErrAddTooFast) are returned as they are, nothing is logged then.
+func (d *DurableSet) TryAdd(key uint64) (added bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err = d.writable(); err != nil {
+		return false, err
+	}
+	added, err = d.set.TryAdd(key)
+	if err != nil || !added {
+		return added, err
+	}
+	return true, d.log(opAdd, key)
+}
+
+// Remove removes key in DurableSet.
+func (d *DurableSet) Remove(key uint64) error {
+	_, err := d.Delete(key)
+	return err
+}
+
+// Delete removes key in DurableSet like Remove,
+// and reports whether key was present before (removed is true).
+func (d *DurableSet) Delete(key uint64) (removed bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err = d.writable(); err != nil {
+		return false, err
+	}
+	if !d.set.Delete(key) {
+		return false, nil
+	}
+	return true, d.log(opRemove, key)
+}
+
+func (d *DurableSet) writable() error {
+	if d.closed {
+		return u64.ErrIsClosed
+	}
+	return d.err
+}
+
+// log writes a record, it's called under the lock.
+func (d *DurableSet) log(op uint8, key uint64) error {
+
+	var rec [recordSize]byte
+	encodeRecord(rec[:], op, key)
+	if _, err := d.seg.Write(rec[:]); err != nil {
+		d.err = err
+		return err
+	}
+	d.seq++
+	d.segOff += recordSize
+	d.dirty = true
+
+	if d.opts.sync == SyncAlways {
+		if err := d.sync(); err != nil {
+			return err
+		}
+	}
+	if d.segOff >= d.opts.segmentSize {
+		if err := d.rotate(); err != nil {
+			d.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// sync fsyncs the current segment if it's dirty, it's called under the lock.
+func (d *DurableSet) sync() error {
+	if !d.dirty {
+		return nil
+	}
+	if err := d.seg.Sync(); err != nil {
+		d.err = err
+		return err
+	}
+	d.dirty = false
+	return nil
+}
+
+// Sync fsyncs records which aren't fsynced, for SyncInterval & SyncNever.
+func (d *DurableSet) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.writable(); err != nil {
+		return err
+	}
+	return d.sync()
+}
+
+func (d *DurableSet) syncLoop() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.opts.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			if !d.closed && d.err == nil {
+				_ = d.sync() // It's sticky, Add/Remove will return it.
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// Contains returns the key in set or not.
+func (d *DurableSet) Contains(key uint64) bool {
+	return d.set.Contains(key)
+}
+
+// Range calls f sequentially for each key present in the DurableSet,
+// see u64.Set.Range for details.
+func (d *DurableSet) Range(f func(key uint64) bool) {
+	d.set.Range(f)
+}
+
+// GetUsage returns DurableSet capacity & usage.
+func (d *DurableSet) GetUsage() (total, usage int) {
+	return d.set.GetUsage()
+}
+
+// Seq returns the seq of the last record.
+func (d *DurableSet) Seq() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.seq
+}
+
+// Close fsyncs & closes DurableSet.
+func (d *DurableSet) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	var err error
+	if d.seg != nil { // It's nil if rotating failed.
+		err = d.closeSeg()
+	}
+	d.mu.Unlock()
+
+	close(d.done)
+	d.wg.Wait()
+
+	d.snapMu.Lock() // Wait for the running Snapshot.
+	d.set.Close()
+	d.snapMu.Unlock()
+	return err
+}
+
+// add adds key into s, waiting for expanding if it's too fast.
+func add(s *u64.Set, key uint64) error {
+	err := s.Add(key)
+	for err == u64.ErrAddTooFast {
+		runtime.Gosched()
+		err = s.Add(key)
+	}
+	return err
+}
+
+func apply(s *u64.Set, op uint8, key uint64) error {
+	if op == opRemove {
+		s.Remove(key)
+		return nil
+	}
+	return add(s, key)
+}
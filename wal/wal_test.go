@@ -0,0 +1,250 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/templexxx/u64"
+)
+
+func addKeys(t *testing.T, d *DurableSet, start, end uint64) {
+	t.Helper()
+	for i := start; i < end; i++ {
+		err := d.Add(i)
+		for err == u64.ErrAddTooFast {
+			time.Sleep(time.Millisecond)
+			err = d.Add(i)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkKeys checks d has keys in [start, end) but the removed ones (odd keys < removed).
+func checkKeys(t *testing.T, d *DurableSet, start, end, removed uint64) {
+	t.Helper()
+	for i := start; i < end; i++ {
+		want := i >= removed || i%2 == 0
+		if d.Contains(i) != want {
+			t.Fatalf("key %d: exp %t", i, want)
+		}
+	}
+	if d.Contains(end) {
+		t.Fatal("should not have", end)
+	}
+}
+
+func removeOdd(t *testing.T, d *DurableSet, end uint64) {
+	t.Helper()
+	for i := uint64(1); i < end; i += 2 {
+		removed, err := d.Delete(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !removed {
+			t.Fatal("should be removed", i)
+		}
+	}
+}
+
+func TestDurableSet(t *testing.T) {
+
+	for _, p := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		dir := t.TempDir()
+		d, err := Open(dir, 1024, WithSync(p), WithSyncInterval(time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addKeys(t, d, 0, 2048)
+		removeOdd(t, d, 512)
+
+		added, err := d.TryAdd(2)
+		if err != nil || added {
+			t.Fatal("existed key should not be added", err)
+		}
+		if d.Seq() != 2048+256 { // Only changes are logged.
+			t.Fatal("seq mismatched", d.Seq())
+		}
+		if err = d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = d.Add(1); err != u64.ErrIsClosed {
+			t.Fatal("should be closed", err)
+		}
+
+		d, err = Open(dir, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkKeys(t, d, 0, 2048, 512)
+		if d.Seq() != 2048+256 {
+			t.Fatal("seq mismatched", d.Seq())
+		}
+		addKeys(t, d, 2048, 2100)
+		d.Close()
+
+		d, err = Open(dir, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkKeys(t, d, 0, 2100, 512)
+		d.Close()
+	}
+}
+
+func TestDurableSet_Rotate(t *testing.T) {
+
+	dir := t.TempDir()
+	d, err := Open(dir, 1024, WithSegmentSize(recordSize*100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addKeys(t, d, 0, 1000)
+	d.Close()
+
+	_, segs, err := listFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) != 11 { // The last one is empty.
+		t.Fatal("segments mismatched", len(segs))
+	}
+
+	d, err = Open(dir, 1024, WithSegmentSize(recordSize*100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	checkKeys(t, d, 0, 1000, 0)
+}
+
+func TestDurableSet_Snapshot(t *testing.T) {
+
+	dir := t.TempDir()
+	d, err := Open(dir, 1024, WithSegmentSize(recordSize*100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addKeys(t, d, 0, 1000)
+	if err = d.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	removeOdd(t, d, 200)
+	addKeys(t, d, 1000, 1100)
+	if err = d.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	addKeys(t, d, 1100, 1200)
+	d.Close()
+
+	snaps, segs, err := listFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 || snaps[0] != 1000+100+100 {
+		t.Fatal("old snapshots should be removed", snaps)
+	}
+	for _, first := range segs {
+		if first <= snaps[0] {
+			t.Fatal("covered segments should be removed", segs)
+		}
+	}
+
+	d, err = Open(dir, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	checkKeys(t, d, 0, 1200, 200)
+	if d.Seq() != 1000+100+100+100 {
+		t.Fatal("seq mismatched", d.Seq())
+	}
+}
+
+func TestDurableSet_TornTail(t *testing.T) {
+
+	dir := t.TempDir()
+	d, err := Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addKeys(t, d, 0, 100)
+	d.Close()
+
+	_, segs, _ := listFiles(dir)
+	path := segPath(dir, segs[len(segs)-1])
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec [recordSize]byte
+	encodeRecord(rec[:], opAdd, 100)
+	_, _ = f.Write(rec[:recordSize-1]) // Torn record.
+	f.Close()
+
+	d, err = Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, d, 0, 100, 0)
+	addKeys(t, d, 100, 110)
+	d.Close()
+
+	d, err = Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	checkKeys(t, d, 0, 110, 0)
+}
+
+func TestDurableSet_Corrupted(t *testing.T) {
+
+	dir := t.TempDir()
+	d, err := Open(dir, 1024, WithSegmentSize(recordSize*10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addKeys(t, d, 0, 100)
+	if err = d.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	addKeys(t, d, 100, 150)
+	d.Close()
+
+	snaps, segs, _ := listFiles(dir)
+
+	// Damaged record in a sealed segment.
+	p := segPath(dir, segs[0])
+	b, _ := os.ReadFile(p)
+	b[recordSize] ^= 1
+	_ = os.WriteFile(p, b, 0o644)
+	if _, err = Open(dir, 1024); err != ErrCorrupted {
+		t.Fatal("should be corrupted", err)
+	}
+	b[recordSize] ^= 1
+	_ = os.WriteFile(p, b, 0o644)
+
+	// Damaged snapshot.
+	p = snapPath(dir, snaps[0])
+	b, _ = os.ReadFile(p)
+	b[0] ^= 1
+	_ = os.WriteFile(p, b, 0o644)
+	if _, err = Open(dir, 1024); err != ErrCorrupted {
+		t.Fatal("should be corrupted", err)
+	}
+	b[0] ^= 1
+	_ = os.WriteFile(p, b, 0o644)
+
+	// Left by crashed snapshot.
+	_ = os.WriteFile(filepath.Join(dir, "snap-tmp"+tmpSuffix), []byte{1}, 0o644)
+	d, err = Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	checkKeys(t, d, 0, 150, 0)
+}
@@ -0,0 +1,220 @@
+package u64
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// minGens is the minimum generations of WindowSet.
+// With only one generation, rotating will drop all keys at once,
+// and the newest Set may be closed when someone is adding.
+const minGens = 2
+
+// WindowSet is a sliding-window Set made of a ring of Sets (generations).
+//
+// Keys are added into the newest generation,
+// every interval the ring rotates: a new Set becomes the newest one,
+// and the oldest one is dropped wholesale.
+// So a key stays in WindowSet for at least (gens-1)*interval after last adding,
+// and there is no per-key timestamp.
+//
+// Contains searches all live generations, it's wait-free as Set.Contains.
+type WindowSet struct {
+	cap int
+	// ring is the container of generations (*Set),
+	// ring[head%len(ring)] is the newest one.
+	ring []unsafe.Pointer
+	head uint64
+
+	// mu protects rotating & closing.
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewWindowSet creates a new WindowSet with gens generations.
+// cap is the capacity of each new generation, see New for details.
+//
+// If interval > 0, the ring rotates every interval in background,
+// otherwise the caller should call Rotate by itself.
+// If gens < 2, using 2.
+func NewWindowSet(cap, gens int, interval time.Duration) (*WindowSet, error) {
+
+	if gens < minGens {
+		gens = minGens
+	}
+
+	s, err := New(cap)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WindowSet{
+		cap:  cap,
+		ring: make([]unsafe.Pointer, gens),
+		done: make(chan struct{}),
+	}
+	w.ring[0] = unsafe.Pointer(s)
+
+	if interval > 0 {
+		w.wg.Add(1)
+		go w.rotateLoop(interval)
+	}
+	return w, nil
+}
+
+func (w *WindowSet) rotateLoop(interval time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			_ = w.Rotate()
+		}
+	}
+}
+
+// Rotate makes a new empty generation as the newest one,
+// and drops the oldest one.
+func (w *WindowSet) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrIsClosed
+	}
+
+	s, err := New(w.cap)
+	if err != nil {
+		return err
+	}
+
+	head := atomic.LoadUint64(&w.head) + 1
+	slot := head % uint64(len(w.ring))
+	old := atomic.SwapPointer(&w.ring[slot], unsafe.Pointer(s))
+	atomic.StoreUint64(&w.head, head)
+	if old != nil {
+		(*Set)(old).Close()
+	}
+	return nil
+}
+
+// newest returns the newest generation.
+func (w *WindowSet) newest() *Set {
+	head := atomic.LoadUint64(&w.head)
+	return (*Set)(atomic.LoadPointer(&w.ring[head%uint64(len(w.ring))]))
+}
+
+// Add adds key into the newest generation.
+// Return nil if succeed.
+func (w *WindowSet) Add(key uint64) error {
+	_, err := w.TryAdd(key)
+	return err
+}
+
+// TryAdd adds key into the newest generation,
+// and reports whether key was absent in all live generations before.
+//
+// It's atomic for "first time seen" checks when there is only one goroutine adding,
+// and rotating happens between two TryAdd.
+func (w *WindowSet) TryAdd(key uint64) (added bool, err error) {
+
+	seen := w.containsOlder(key)
+	for {
+		s := w.newest()
+		if s == nil {
+			return false, ErrIsClosed
+		}
+		added, err = s.TryAdd(key)
+		if err == ErrIsClosed && w.IsRunning() {
+			continue // The newest one became the oldest one and be dropped, try again.
+		}
+		return added && !seen, err
+	}
+}
+
+// Contains returns the key in any live generation or not.
+func (w *WindowSet) Contains(key uint64) bool {
+	head := atomic.LoadUint64(&w.head)
+	n := uint64(len(w.ring))
+	for i := uint64(0); i < n; i++ {
+		p := atomic.LoadPointer(&w.ring[(head-i)%n])
+		if p != nil && (*Set)(p).Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsOlder returns the key in any generation except the newest one or not.
+func (w *WindowSet) containsOlder(key uint64) bool {
+	head := atomic.LoadUint64(&w.head)
+	n := uint64(len(w.ring))
+	for i := uint64(1); i < n; i++ {
+		p := atomic.LoadPointer(&w.ring[(head-i)%n])
+		if p != nil && (*Set)(p).Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes key in all live generations.
+func (w *WindowSet) Remove(key uint64) {
+	for i := range w.ring {
+		p := atomic.LoadPointer(&w.ring[i])
+		if p != nil {
+			(*Set)(p).Remove(key)
+		}
+	}
+}
+
+// GetUsage returns the sum of capacity & usage of all live generations.
+// A key added in different generations is counted more than once.
+func (w *WindowSet) GetUsage() (total, usage int) {
+	for i := range w.ring {
+		p := atomic.LoadPointer(&w.ring[i])
+		if p != nil {
+			t, u := (*Set)(p).GetUsage()
+			total += t
+			usage += u
+		}
+	}
+	return total, usage
+}
+
+// IsRunning returns WindowSet is running or not.
+func (w *WindowSet) IsRunning() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.closed
+}
+
+// Close stops rotating and closes all generations.
+func (w *WindowSet) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.done)
+	w.mu.Unlock()
+
+	w.wg.Wait()
+
+	for i := range w.ring {
+		p := atomic.SwapPointer(&w.ring[i], nil)
+		if p != nil {
+			(*Set)(p).Close()
+		}
+	}
+}
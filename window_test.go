@@ -0,0 +1,137 @@
+package u64
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowSet_Rotate(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	gens := 3
+	w, err := NewWindowSet(4096, gens, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	n := 1024
+	for i := 0; i < n; i++ {
+		err = w.Add(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for r := 0; r < gens-1; r++ {
+		err = w.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if !w.Contains(uint64(i)) {
+				t.Fatal("should have key")
+			}
+		}
+	}
+
+	err = w.Rotate() // The generation which has keys is the oldest one now, drop it.
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if w.Contains(uint64(i)) {
+			t.Fatal("should not have key")
+		}
+	}
+	_, usage := w.GetUsage()
+	if usage != 0 {
+		t.Fatal("usage mismatched", usage)
+	}
+}
+
+func TestWindowSet_TryAdd(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	w, err := NewWindowSet(4096, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 1024; i++ {
+		added, err := w.TryAdd(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added {
+			t.Fatal("should be added")
+		}
+	}
+
+	err = w.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys are in the older generation, they have been seen.
+	for i := 0; i < 1024; i++ {
+		added, err := w.TryAdd(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added {
+			t.Fatal("should not be added")
+		}
+	}
+
+	w.Remove(1)
+	if w.Contains(1) {
+		t.Fatal("should not have key")
+	}
+}
+
+func TestWindowSet_Interval(t *testing.T) {
+
+	if !isAtomic256 {
+		t.Skip(ErrUnsupported.Error())
+	}
+
+	w, err := NewWindowSet(0, 2, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Add(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for w.Contains(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("key should be dropped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w.Close()
+	if w.IsRunning() {
+		t.Fatal("should be closed")
+	}
+	if w.Contains(1) {
+		t.Fatal("should not have key")
+	}
+	if err = w.Add(1); err != ErrIsClosed {
+		t.Fatal("should be closed")
+	}
+	if err = w.Rotate(); err != ErrIsClosed {
+		t.Fatal("should be closed")
+	}
+}